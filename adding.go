@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ============== 向归档添加文件与目录 ==============
+
+// AddOptions 控制 -a 加入的内容在归档中的位置与范围
+type AddOptions struct {
+	Into    string   // 归档内的目标目录，空为根目录；可用 "inner.zip!/lib/" 指向嵌套归档
+	Parents bool     // 保留命令行给出的相对路径，而不是只取最后一级名字
+	Include []string // 目录递归时只加入匹配的文件
+	Exclude []string // 目录递归时跳过匹配的文件与目录
+}
+
+// set 表示是否给出了任何只对 -a 有意义的选项
+func (o *AddOptions) set() bool {
+	return o.Into != "" || o.Parents || len(o.Include) > 0 || len(o.Exclude) > 0
+}
+
+// collectAdds 把命令行给出的文件与目录展开为逐条的 EditAdd，放在归档内的 into 目录下，目录递归加入。
+// 同名条目以后给出的为准；归档自身与不存在的参数跳过并给出警告
+func collectAdds(files []string, absArchive, into string, o *AddOptions) ([]EditAdd, error) {
+	for _, pattern := range append(append([]string(nil), o.Include...), o.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s'", pattern)
+		}
+	}
+	var adds []EditAdd
+	index := make(map[string]int)
+	for _, file := range files {
+		if absFile, _ := filepath.Abs(file); absFile == absArchive {
+			fmt.Fprintf(os.Stderr, "Warning: cannot add archive '%s' to itself, skipping\n", file)
+			continue
+		}
+		if _, err := os.Lstat(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: file '%s' does not exist, skipping\n", file)
+			continue
+		}
+
+		base := filepath.Base(file)
+		if o.Parents {
+			base = parentsName(file)
+		}
+		if base == "" || base == "." || base == "/" {
+			return nil, fmt.Errorf("cannot add '%s': give a named directory or use --into", file)
+		}
+
+		root := path.Join(into, base)
+		err := walkPath(root, file, func(name, p string, fi os.FileInfo) error {
+			if name != root {
+				if abs, _ := filepath.Abs(p); abs == absArchive {
+					return nil
+				}
+				if !o.admits(name, fi.IsDir()) {
+					if fi.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+			// 有 --include 时目录条目由其下的文件带出，空目录不加入
+			if fi.IsDir() && len(o.Include) > 0 {
+				return nil
+			}
+			if i, ok := index[name]; ok {
+				adds[i].Path = p
+				return nil
+			}
+			index[name] = len(adds)
+			adds = append(adds, EditAdd{Name: name, Path: p})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+	}
+	return adds, nil
+}
+
+// admits 按 include / exclude 过滤目录递归中遇到的条目，模式匹配条目名本身或其在归档中的路径
+func (o *AddOptions) admits(name string, dir bool) bool {
+	for _, pattern := range o.Exclude {
+		if addPatternMatches(pattern, name) {
+			return false
+		}
+	}
+	if dir || len(o.Include) == 0 {
+		return true
+	}
+	for _, pattern := range o.Include {
+		if addPatternMatches(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func addPatternMatches(pattern, name string) bool {
+	if ok, _ := path.Match(pattern, path.Base(name)); ok {
+		return true
+	}
+	ok, _ := path.Match(strings.TrimSuffix(strings.TrimPrefix(pattern, "./"), "/"), name)
+	return ok
+}
+
+// splitInto 拆分 --into 给出的位置："inner.zip!/lib/" 的嵌套链为 [inner.zip]，归档内目录为 "lib"。
+// 目录不含开头与结尾的 "/"，也不会越出归档根目录
+func splitInto(into string) (chain []string, dir string) {
+	parts := strings.Split(filepath.ToSlash(into), nestedSep)
+	for _, hop := range parts[:len(parts)-1] {
+		chain = append(chain, cleanEntryName(hop))
+	}
+	return chain, strings.Trim(path.Clean("/"+parts[len(parts)-1]), "/")
+}
+
+// parentsName 返回 --parents 下文件在归档中的相对路径：去掉开头的 "/" 与 "../"
+func parentsName(file string) string {
+	name := filepath.ToSlash(filepath.Clean(file))
+	for {
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(name, "/"), "../")
+		if trimmed == name {
+			break
+		}
+		name = trimmed
+	}
+	if name == ".." {
+		return ""
+	}
+	return name
+}
+
+// stageAdds 把要加入的条目复制到解包出的临时目录，供不支持直接编辑的格式重新打包
+func stageAdds(tmpdir string, adds []EditAdd) error {
+	times := make(dirTimes)
+	for _, add := range adds {
+		target := filepath.Join(tmpdir, filepath.FromSlash(add.Name))
+		fi, err := os.Lstat(add.Path)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := copyFile(add.Path, target); err != nil {
+				return fmt.Errorf("failed to copy %s: %v", add.Path, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		os.Chmod(target, fi.Mode().Perm())
+		times[target] = fi.ModTime()
+	}
+	times.restore()
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// ============== 单流压缩编解码器 ==============
+
+// codec 描述一种单流压缩格式。注册后会同时得到 "tar.<kind>" 与裸压缩流两种 Format
+type codec struct {
+	kind          archiveKind // 裸压缩流的格式名，如 "gz"
+	tarKind       archiveKind // 内部为 tar 时的格式名，如 "tar.gz"
+	magic         []byte
+	extensions    []string // 裸压缩流的扩展名
+	tarExtensions []string // tar 复合格式的扩展名
+	tool          string   // 外部命令，未提供原生 reader/writer 时使用
+	threadsFlag   string   // 外部命令指定线程数的参数，如 zstd 的 "-T"，为空表示不支持多线程
+
+	// 原生实现，为 nil 时通过 tool 的 -dc / -c 管道完成。level 为 0 表示默认压缩级别
+	reader func(io.Reader) (io.Reader, error)
+	writer func(w io.Writer, level int) (io.WriteCloser, error)
+
+	// level 从压缩流开头推断原文件使用的压缩级别，无法推断时返回 0
+	level func(header []byte) int
+}
+
+var codecs []*codec
+
+func registerCodec(c *codec) {
+	codecs = append(codecs, c)
+	registerFormat(&tarFormat{codec: c})
+	registerFormat(&streamFormat{codec: c})
+}
+
+func init() {
+	registerCodec(&codec{
+		kind:          "gz",
+		tarKind:       "tar.gz",
+		magic:         []byte{0x1f, 0x8b},
+		extensions:    []string{".gz"},
+		tarExtensions: []string{".tar.gz", ".tgz"},
+		tool:          "gzip",
+		reader: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		writer: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		// 头部的 XFL 字节记录了压缩时是否使用了最高或最快级别
+		level: func(header []byte) int {
+			if len(header) < 9 {
+				return 0
+			}
+			switch header[8] {
+			case 2:
+				return gzip.BestCompression
+			case 4:
+				return gzip.BestSpeed
+			}
+			return 0
+		},
+	})
+	registerCodec(&codec{
+		kind:          "bz2",
+		tarKind:       "tar.bz2",
+		magic:         []byte("BZh"),
+		extensions:    []string{".bz2"},
+		tarExtensions: []string{".tar.bz2", ".tbz2", ".tbz"},
+		tool:          "bzip2",
+		reader: func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r), nil
+		},
+		// "BZh" 之后的数字是块大小，即 bzip2 -1 ~ -9
+		level: func(header []byte) int {
+			if len(header) < 4 || header[3] < '1' || header[3] > '9' {
+				return 0
+			}
+			return int(header[3] - '0')
+		},
+	})
+	registerCodec(&codec{
+		kind:          "xz",
+		tarKind:       "tar.xz",
+		magic:         []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		extensions:    []string{".xz"},
+		tarExtensions: []string{".tar.xz", ".txz"},
+		tool:          "xz",
+		threadsFlag:   "-T",
+	})
+	registerCodec(&codec{
+		kind:          "zst",
+		tarKind:       "tar.zst",
+		magic:         []byte{0x28, 0xb5, 0x2f, 0xfd},
+		extensions:    []string{".zst"},
+		tarExtensions: []string{".tar.zst", ".tzst"},
+		tool:          "zstd",
+		threadsFlag:   "-T",
+	})
+	registerCodec(&codec{
+		kind:          "lz4",
+		tarKind:       "tar.lz4",
+		magic:         []byte{0x04, 0x22, 0x4d, 0x18},
+		extensions:    []string{".lz4"},
+		tarExtensions: []string{".tar.lz4"},
+		tool:          "lz4",
+	})
+}
+
+// streamReader 把解码器与底层文件组合在一起，关闭时释放文件
+type streamReader struct {
+	io.Reader
+	io.Closer
+}
+
+// cmdReadCloser 把外部解压命令的标准输出包装为 ReadCloser，关闭时回收进程
+type cmdReadCloser struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+	src io.Closer
+}
+
+func (c *cmdReadCloser) Close() error {
+	c.ReadCloser.Close()
+	err := c.cmd.Wait()
+	c.src.Close()
+	return err
+}
+
+// closeEarly 在数据流尚未读完时关闭它，外部解压命令会被直接结束而不是等它输出完
+func closeEarly(rc io.ReadCloser) {
+	if k, ok := rc.(*cmdReadCloser); ok {
+		k.cmd.Process.Kill()
+	}
+	rc.Close()
+}
+
+// openDecompressed 打开压缩文件并返回解压后的数据流，c 为 nil 时直接返回原始文件
+func openDecompressed(file string, c *codec) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(f, c, filepath.Base(file), false)
+}
+
+// decompress 在 src 之上套一层解码器，关闭返回值时会一并关闭 src。
+// quiet 为 true 时丢弃外部命令的错误输出，用于只解出开头几个字节的探测场景
+func decompress(src io.ReadCloser, c *codec, name string, quiet bool) (io.ReadCloser, error) {
+	if c == nil {
+		return src, nil
+	}
+
+	if c.reader != nil {
+		r, err := c.reader(src)
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("invalid %s stream: %v", c.kind, err)
+		}
+		return streamReader{r, src}, nil
+	}
+
+	if !commandExists(c.tool) {
+		src.Close()
+		return nil, fmt.Errorf("%s command is required to decompress '%s'", c.tool, name)
+	}
+	cmd := exec.Command(c.tool, "-dc")
+	cmd.Stdin = src
+	if !quiet {
+		cmd.Stderr = os.Stderr
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		src.Close()
+		return nil, err
+	}
+	return &cmdReadCloser{ReadCloser: out, cmd: cmd, src: src}, nil
+}
+
+// cmdWriteCloser 把数据写入外部压缩命令的标准输入，关闭时等待命令结束
+type cmdWriteCloser struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (c *cmdWriteCloser) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		c.cmd.Wait()
+		return err
+	}
+	return c.cmd.Wait()
+}
+
+// newCompressor 返回写入 w 的压缩流，关闭它即可完成压缩（不会关闭 w）。
+// level 为 0 时使用默认级别；threads 大于 0 时交给支持多线程的外部命令，原生实现忽略
+func newCompressor(w io.Writer, c *codec, level, threads int) (io.WriteCloser, error) {
+	if c.writer != nil {
+		return c.writer(w, level)
+	}
+	if !commandExists(c.tool) {
+		return nil, fmt.Errorf("%s command is required to create %s archives", c.tool, c.tarKind)
+	}
+	args := []string{"-c"}
+	if level > 0 {
+		args = append(args, "-"+strconv.Itoa(level))
+	}
+	if threads > 0 && c.threadsFlag != "" {
+		args = append(args, c.threadsFlag+strconv.Itoa(threads))
+	}
+	cmd := exec.Command(c.tool, args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdWriteCloser{WriteCloser: in, cmd: cmd}, nil
+}
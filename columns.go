@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ============== -l 列表的元数据列 ==============
+
+// listColumn 描述一个可以显示在树形列表左侧的元数据列
+type listColumn struct {
+	name   string
+	header string
+	width  int
+	left   bool // 左对齐，默认右对齐
+	value  func(e *Entry) string
+}
+
+var listColumns = []*listColumn{
+	{"mode", "MODE", 10, true, func(e *Entry) string { return modeString(e.Mode) }},
+	{"owner", "OWNER", 15, true, func(e *Entry) string { return orDash(e.Owner) }},
+	{"size", "SIZE", 7, false, func(e *Entry) string { return sizeOrDash(e, e.Size) }},
+	{"packed", "PACKED", 7, false, func(e *Entry) string { return sizeOrDash(e, e.Packed) }},
+	{"ratio", "RATIO", 5, false, func(e *Entry) string {
+		if e.IsDir() {
+			return "-"
+		}
+		return ratioString(e.Packed, e.Size)
+	}},
+	{"mtime", "MODIFIED", 16, true, func(e *Entry) string {
+		if e.ModTime.IsZero() {
+			return "-"
+		}
+		return e.ModTime.Local().Format("2006-01-02 15:04")
+	}},
+	{"crc", "CRC32", 8, true, func(e *Entry) string {
+		if !e.HasCRC {
+			return "-"
+		}
+		return fmt.Sprintf("%08X", e.CRC)
+	}},
+}
+
+// defaultLongColumns 是 --long 显示的列
+const defaultLongColumns = "mode,size,packed,ratio,mtime,link"
+
+// listLayout 决定 -l 的显示方式，为 nil 时保持只显示名称的紧凑视图
+type listLayout struct {
+	columns []*listColumn
+	links   bool // 在名称后显示 "-> 链接目标"
+}
+
+// parseColumns 解析 --columns 的逗号分隔列表，"all" 表示全部列
+func parseColumns(spec string) (*listLayout, error) {
+	layout := &listLayout{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case "all":
+			layout.columns = listColumns
+			layout.links = true
+			continue
+		case "link":
+			layout.links = true
+			continue
+		}
+		col := findColumn(name)
+		if col == nil {
+			return nil, fmt.Errorf("unknown column %q (available: %s)", name, columnNames())
+		}
+		layout.columns = append(layout.columns, col)
+	}
+	return layout, nil
+}
+
+func findColumn(name string) *listColumn {
+	for _, c := range listColumns {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func columnNames() string {
+	names := make([]string, 0, len(listColumns)+1)
+	for _, c := range listColumns {
+		names = append(names, c.name)
+	}
+	return strings.Join(append(names, "link"), ", ")
+}
+
+func (l *listLayout) render(cell func(c *listColumn) string) string {
+	if l == nil || len(l.columns) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range l.columns {
+		if c.left {
+			fmt.Fprintf(&b, "%-*s  ", c.width, cell(c))
+		} else {
+			fmt.Fprintf(&b, "%*s  ", c.width, cell(c))
+		}
+	}
+	return b.String()
+}
+
+// header 返回列标题行
+func (l *listLayout) header() string {
+	return l.render(func(c *listColumn) string { return c.header }) + "NAME"
+}
+
+// cells 返回条目各列的内容；e 为 nil（索引中没有对应条目的目录）时输出空白占位
+func (l *listLayout) cells(e *Entry) string {
+	return l.render(func(c *listColumn) string {
+		if e == nil {
+			return ""
+		}
+		return c.value(e)
+	})
+}
+
+// linkSuffix 返回名称后的链接目标
+func (l *listLayout) linkSuffix(e *Entry) string {
+	if l == nil || !l.links || e == nil || e.Linkname == "" {
+		return ""
+	}
+	return " -> " + e.Linkname
+}
+
+// archiveTotals 汇总单个归档（不含其中嵌套归档的内容）的条目
+type archiveTotals struct {
+	files, dirs int
+	size        int64
+	sizeUnknown bool
+	archiveSize int64 // 归档文件本身的大小
+}
+
+func totalsOf(archive string, entries []Entry) archiveTotals {
+	var t archiveTotals
+	for _, e := range entries {
+		if e.IsDir() {
+			t.dirs++
+			continue
+		}
+		t.files++
+		if e.Size < 0 {
+			t.sizeUnknown = true
+		} else {
+			t.size += e.Size
+		}
+	}
+	t.archiveSize = -1
+	if fi, err := os.Stat(archive); err == nil {
+		t.archiveSize = fi.Size()
+	}
+	return t
+}
+
+func (t archiveTotals) String() string {
+	s := plural(t.files, "file") + ", " + plural(t.dirs, "directory")
+	size := formatSize(t.size)
+	if t.sizeUnknown {
+		size = "at least " + size
+	}
+	s += ", " + size + " uncompressed"
+	if t.archiveSize >= 0 {
+		s += ", " + formatSize(t.archiveSize) + " archive"
+		if !t.sizeUnknown {
+			s += " (" + ratioString(t.archiveSize, t.size) + ")"
+		}
+	}
+	return s
+}
+
+func plural(n int, word string) string {
+	if n != 1 {
+		if strings.HasSuffix(word, "y") {
+			word = strings.TrimSuffix(word, "y") + "ie"
+		}
+		word += "s"
+	}
+	return strconv.Itoa(n) + " " + word
+}
+
+// modeString 以 ls 的形式输出权限位，如 "drwxr-xr-x"、"lrwxrwxrwx"
+func modeString(m os.FileMode) string {
+	kind := "-"
+	switch {
+	case m.IsDir():
+		kind = "d"
+	case m&os.ModeSymlink != 0:
+		kind = "l"
+	}
+	return kind + m.Perm().String()[1:]
+}
+
+// sizeOrDash 目录与未知大小显示为 "-"
+func sizeOrDash(e *Entry, n int64) string {
+	if n < 0 || e.IsDir() {
+		return "-"
+	}
+	return formatSize(n)
+}
+
+// ratioString 返回压缩后大小占原始大小的百分比
+func ratioString(packed, size int64) string {
+	if packed < 0 || size <= 0 {
+		return "-"
+	}
+	return strconv.FormatInt(packed*100/size, 10) + "%"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
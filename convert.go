@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ============== 格式转换 ==============
+
+// converter 把一个归档的全部条目依次交给 sink，recursive 时把嵌套归档展开为同名目录
+type converter struct {
+	opts      *Options
+	recursive bool
+	stream    bool // 条目直接写入新归档，读取时计入总量配额；落到暂存目录时由 writeFile 计入
+}
+
+// convertArchive 把 in 转换为新归档 out，格式按 out 的扩展名决定。
+// 两端都支持流式读写时条目边读边写，不会在磁盘上留下完整的解包副本
+func convertArchive(in, out string, recursive bool, params *CreateParams, opts *Options) error {
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return fmt.Errorf("failed to resolve absolute path for archive: %v", err)
+	}
+	if _, err := os.Lstat(absOut); err == nil {
+		return fmt.Errorf("'%s' already exists", out)
+	}
+
+	inFormat, err := lookupFormat(in)
+	if err != nil {
+		return err
+	}
+	if err := requireCapability(inFormat, CapExtract, "converting"); err != nil {
+		return err
+	}
+	outFormat := formatFromName(out)
+	if outFormat == nil {
+		return fmt.Errorf("cannot tell the archive format from '%s'", filepath.Base(out))
+	}
+	if err := requireCapability(outFormat, CapCreate, "converting"); err != nil {
+		return err
+	}
+	if params.Password != "" {
+		if err := requireCapability(outFormat, CapEncrypt, "encrypting"); err != nil {
+			return err
+		}
+	}
+
+	if params.VolumeSize > 0 {
+		if err := checkVolumesFree(absOut); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Converting: %s (%s) -> %s (%s)\n", in, inFormat.Name(), out, outFormat.Name())
+	opts.resetBudget()
+	c := &converter{opts: opts, recursive: recursive}
+	files := 0
+	count := func(e *Entry) {
+		if e.Mode.IsRegular() || e.Mode&os.ModeSymlink != 0 {
+			files++
+		}
+	}
+
+	err = commitArchive(absOut, false, func(tmpName string) error {
+		// 逐条写入的后端都不能加密，加密时走下面的整体打包
+		if s, ok := outFormat.(Streamer); ok && params.Password == "" {
+			w, err := s.NewEntryWriter(tmpName, params)
+			if err != nil {
+				return err
+			}
+			c.stream = true
+			err = c.walk(in, inFormat, "", 0, func(e *Entry, r io.Reader) error {
+				count(e)
+				return w.WriteEntry(e, r)
+			})
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+
+		builder, ok := outFormat.(Builder)
+		if !ok {
+			return fmt.Errorf("converting to %s archives is not supported", outFormat.Name())
+		}
+		// 不能逐条写入的格式（7z）或需要加密时先把条目落到临时目录，再整体打包
+		dir, err := createTempDir("ub_conv_")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		// 暂存目录同样占用临时空间
+		opts.tracker()
+		stageOpts := *opts
+		stageOpts.inTemp = true
+		root, err := newExtractRoot("", dir, &stageOpts)
+		if err != nil {
+			return err
+		}
+		dirs := dirTimes{}
+		err = c.walk(in, inFormat, "", 0, func(e *Entry, r io.Reader) error {
+			count(e)
+			return root.stageEntry(e, r, dirs)
+		})
+		dirs.restore()
+		if err != nil {
+			return err
+		}
+		adds, err := sourceDirAdds(dir)
+		if err != nil {
+			return err
+		}
+		return builder.Build(tmpName, adds, params)
+	}, func(tmpName string) error {
+		return verifyFileCount(outFormat, tmpName, files)
+	})
+	if err != nil || params.VolumeSize == 0 {
+		return err
+	}
+	return writeVolumes(absOut, params.VolumeSize)
+}
+
+// walk 依次读出 archive 的条目并加上前缀 prefix。支持 Walker 的格式直接流式读取，其余格式先解包到临时目录
+func (c *converter) walk(archive string, format Format, prefix string, depth int, sink func(e *Entry, r io.Reader) error) error {
+	// 条目数总在这里计入，数据量只在流式写入时由 limitReader 计入
+	counter := &extractRoot{opts: c.opts}
+	if fi, err := os.Stat(archive); err == nil {
+		counter.packed = fi.Size()
+	}
+	var root *extractRoot
+	if c.stream {
+		root = counter
+	}
+	fn := func(e *Entry, r io.Reader) error {
+		name := cleanEntryName(e.Name)
+		if name == "" || name == "." {
+			return nil
+		}
+		if err := counter.addEntry(); err != nil {
+			return err
+		}
+		e.Name = path.Join(prefix, name)
+		if e.Mode.IsRegular() && e.Linkname != "" {
+			e.Linkname = path.Join(prefix, cleanEntryName(e.Linkname))
+		}
+		if c.recursive && r != nil && e.Mode.IsRegular() && e.Linkname == "" {
+			return c.maybeNested(e, r, depth, root, sink)
+		}
+		return sink(e, limitReader(r, root))
+	}
+
+	if w, ok := format.(Walker); ok {
+		if err := w.Walk(archive, fn); err != errNotStreamable {
+			return err
+		}
+	}
+	// 解包时已把总量与条目数计入配额，条目交给 sink 时还会再计一次，这里退回解包的计数，
+	// 临时空间照常累计
+	b := c.opts.tracker()
+	written, entries := b.written, b.entries
+	tmpdir, err := extractToTemp(archive, "ub_conv_", c.opts)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", filepath.Base(archive), err)
+	}
+	defer os.RemoveAll(tmpdir)
+	b.written, b.entries = written, entries
+	return walkDirEntries(tmpdir, fn)
+}
+
+// limitReader 让流式转换读出的条目数据计入配额，root 为 nil 时原样返回
+func limitReader(r io.Reader, root *extractRoot) io.Reader {
+	if r == nil || root == nil {
+		return r
+	}
+	return &limitedReader{r: r, root: root}
+}
+
+// maybeNested 判断条目是否是嵌套归档：是则展开为去掉扩展名的同名目录，否则原样交给 sink。
+// 嵌套归档的临时副本计入总量与临时空间配额
+func (c *converter) maybeNested(e *Entry, r io.Reader, depth int, root *extractRoot, sink func(e *Entry, r io.Reader) error) error {
+	br := bufio.NewReaderSize(r, entryHeadSize)
+	head, _ := br.Peek(entryHeadSize)
+	if !sniffEntry(e.Name, head) || !c.opts.Limits.allowsDepth(depth+1) {
+		return sink(e, limitReader(br, root))
+	}
+
+	tmpdir, nestedFile, err := readerToTemp(br, e.Name, "ub_conv_", c.opts)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	format, err := lookupFormat(nestedFile)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Expanding nested archive: %s\n", e.Name)
+	dir := path.Join(path.Dir(e.Name), stripArchiveExt(e.Name))
+	if err := sink(&Entry{Name: dir, Mode: os.ModeDir | 0755, ModTime: e.ModTime}, nil); err != nil {
+		return err
+	}
+	return c.walk(nestedFile, format, dir, depth+1, sink)
+}
+
+// walkDirEntries 把解包出的目录当作条目流依次交给 fn
+func walkDirEntries(dir string, fn func(e *Entry, r io.Reader) error) error {
+	return walkSourceDir(dir, func(name, p string, fi os.FileInfo) error {
+		e := &Entry{Name: name, Size: fi.Size(), Packed: -1, Mode: fi.Mode(), ModTime: fi.ModTime()}
+		switch {
+		case fi.IsDir():
+			e.Size = 0
+			return fn(e, nil)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			e.Linkname = link
+			return fn(e, nil)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return fn(e, f)
+		}
+		return nil
+	})
+}
+
+// stageEntry 把条目写到暂存目录，路径与链接同样经过安全校验
+func (root *extractRoot) stageEntry(e *Entry, r io.Reader, dirs dirTimes) error {
+	target, err := root.target(e.Name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case e.IsDir():
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		os.Chmod(target, e.Mode.Perm())
+		dirs[target] = e.ModTime
+	case e.Mode&os.ModeSymlink != 0:
+		if err := root.checkSymlink(e.Name, target, e.Linkname); err != nil {
+			return err
+		}
+		return writeSymlink(target, e.Linkname)
+	case e.Mode.IsRegular() && e.Linkname != "":
+		linkTarget, err := root.target(e.Linkname)
+		if err != nil {
+			return err
+		}
+		os.Remove(target)
+		return os.Link(linkTarget, target)
+	case e.Mode.IsRegular():
+		return root.writeFile(target, r, e.Mode.Perm(), e.ModTime)
+	}
+	return nil
+}
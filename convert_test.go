@@ -0,0 +1,34 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertChargesFallbackOnce(t *testing.T) {
+	tmp := t.TempDir()
+	in := filepath.Join(tmp, "f.gz")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2<<20)
+	rand.Read(data)
+	zw := gzip.NewWriter(f)
+	zw.Write(data)
+	zw.Close()
+	f.Close()
+
+	// 裸 .gz 不能顺序读取，先解包到临时目录再逐条写入，同一份数据只计一次总量
+	opts := &Options{Limits: Limits{MaxTotalSize: 3 << 20}}
+	if err := convertArchive(in, filepath.Join(tmp, "ok.tar"), false, &CreateParams{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	opts = &Options{Limits: Limits{MaxTotalSize: 1 << 20}}
+	if err := convertArchive(in, filepath.Join(tmp, "big.tar"), false, &CreateParams{}, opts); !isLimitError(err) {
+		t.Fatalf("expected a limit error, got %v", err)
+	}
+}
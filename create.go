@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ============== 创建新归档 ==============
+
+// createArchive 把 files 打包为新归档 archive，格式按扩展名决定。
+// 条目按归档内路径排序，相同的输入总是得到顺序相同的归档
+func createArchive(archive string, files []string, addOpts *AddOptions, params *CreateParams) error {
+	absArchive, err := filepath.Abs(archive)
+	if err != nil {
+		return fmt.Errorf("failed to resolve absolute path for archive: %v", err)
+	}
+	if _, err := os.Lstat(absArchive); err == nil {
+		return fmt.Errorf("'%s' already exists (use -a to add to it)", archive)
+	}
+
+	format := formatFromName(archive)
+	if format == nil {
+		return fmt.Errorf("cannot tell the archive format from '%s'", filepath.Base(archive))
+	}
+	if err := requireCapability(format, CapCreate, "creating archives"); err != nil {
+		return err
+	}
+	if params.Password != "" {
+		if err := requireCapability(format, CapEncrypt, "encrypting"); err != nil {
+			return err
+		}
+	}
+	builder, ok := format.(Builder)
+	if !ok {
+		return fmt.Errorf("creating %s archives is not supported", format.Name())
+	}
+
+	if params.VolumeSize > 0 {
+		if err := checkVolumesFree(absArchive); err != nil {
+			return err
+		}
+	}
+
+	_, into := splitInto(addOpts.Into)
+	adds, err := collectAdds(files, absArchive, into, addOpts)
+	if err != nil {
+		return err
+	}
+	if len(adds) == 0 {
+		return fmt.Errorf("nothing to add, %s was not created", archive)
+	}
+	sort.Slice(adds, func(i, j int) bool { return adds[i].Name < adds[j].Name })
+
+	for _, add := range adds {
+		fmt.Printf("Adding: %s\n", add.Name)
+	}
+	fmt.Printf("Creating %s archive: %s\n", format.Name(), archive)
+	err = commitArchive(absArchive, false, func(tmpName string) error {
+		return builder.Build(tmpName, adds, params)
+	}, func(tmpName string) error {
+		return verifyBuild(format, tmpName, adds)
+	})
+	if err != nil || params.VolumeSize == 0 {
+		return err
+	}
+	return writeVolumes(absArchive, params.VolumeSize)
+}
+
+// verifyBuild 重新读取新建的归档，确认其中的文件数与要加入的一致
+func verifyBuild(format Format, archive string, adds []EditAdd) error {
+	expected := 0
+	for _, add := range adds {
+		if fi, err := os.Lstat(add.Path); err == nil && (fi.Mode().IsRegular() || fi.Mode()&os.ModeSymlink != 0) {
+			expected++
+		}
+	}
+	return verifyFileCount(format, archive, expected)
+}
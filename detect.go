@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// archiveKind 表示识别出的归档/压缩格式，取值即该格式的惯用扩展名
+type archiveKind string
+
+const (
+	kindUnknown archiveKind = ""
+	kindZip     archiveKind = "zip"
+	kindTar     archiveKind = "tar"
+	kind7z      archiveKind = "7z"
+	kindRar     archiveKind = "rar"
+	kindCpio    archiveKind = "cpio"
+	kindAr      archiveKind = "ar"
+	kindISO     archiveKind = "iso"
+	kindCab     archiveKind = "cab"
+	kindRpm     archiveKind = "rpm"
+	kindWim     archiveKind = "wim"
+	kindArj     archiveKind = "arj"
+	kindLzh     archiveKind = "lzh"
+	kindLzma    archiveKind = "lzma"
+	kindZ       archiveKind = "Z"
+	kindDmg     archiveKind = "dmg"
+	kindVhd     archiveKind = "vhd"
+)
+
+// sniffSize 需要覆盖 ISO9660 的卷描述符 (位于 0x8001 / 0x8801 / 0x9001)
+const sniffSize = 0x9006
+
+type magicSignature struct {
+	offset int
+	magic  []byte
+}
+
+// probe 缓存一次识别过程中读到的文件头，以及各压缩流解压后的开头部分
+type probe struct {
+	name   string
+	header []byte
+	open   func() (io.ReadCloser, error) // 重新从头读取完整内容
+	inner  map[*codec][]byte
+}
+
+func newProbe(path string) (*probe, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return &probe{
+		name:   path,
+		header: header[:n],
+		open:   func() (io.ReadCloser, error) { return os.Open(path) },
+		inner:  make(map[*codec][]byte),
+	}, nil
+}
+
+// newHeadProbe 用归档条目开头的若干字节构造 probe，用于不落盘地识别嵌套归档
+func newHeadProbe(name string, head []byte) *probe {
+	return &probe{
+		name:   name,
+		header: head,
+		open:   func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(head)), nil },
+		inner:  make(map[*codec][]byte),
+	}
+}
+
+func (p *probe) hasMagic(sig magicSignature) bool {
+	end := sig.offset + len(sig.magic)
+	return len(p.header) >= end && bytes.Equal(p.header[sig.offset:end], sig.magic)
+}
+
+func (p *probe) hasAnyMagic(sigs []magicSignature) bool {
+	for _, sig := range sigs {
+		if p.hasMagic(sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// innerHeader 返回压缩流解压后的前 512 字节，解码器不可用时返回 false
+func (p *probe) innerHeader(c *codec) ([]byte, bool) {
+	if inner, ok := p.inner[c]; ok {
+		return inner, inner != nil
+	}
+	inner, ok := peekDecompressed(p, c, 512)
+	if !ok {
+		inner = nil
+	}
+	p.inner[c] = inner
+	return inner, ok
+}
+
+// compressedTar 判断压缩流内部是否为 tar；无法解码时退回到扩展名判断
+func (p *probe) compressedTar(c *codec) bool {
+	if !p.hasMagic(magicSignature{0, c.magic}) {
+		return false
+	}
+	if inner, ok := p.innerHeader(c); ok {
+		return isTarHeader(inner)
+	}
+	return hasAnySuffix(p.name, c.tarExtensions)
+}
+
+func hasAnySuffix(path string, exts []string) bool {
+	lower := strings.ToLower(filepath.Base(path))
+	for _, ext := range exts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTarHeader 校验 512 字节的 tar 头，兼容没有 ustar 魔数的老式 v7 tar
+func isTarHeader(block []byte) bool {
+	if len(block) < 512 {
+		return false
+	}
+	if bytes.Equal(block[257:262], []byte("ustar")) {
+		return true
+	}
+	if block[0] == 0 {
+		return false
+	}
+
+	field := strings.TrimRight(string(block[148:156]), " \x00")
+	want, err := strconv.ParseInt(strings.TrimSpace(field), 8, 64)
+	if err != nil {
+		return false
+	}
+	var sum int64
+	for i, b := range block[:512] {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		sum += int64(b)
+	}
+	return sum == want
+}
+
+// peekDecompressed 解压出压缩流开头的 n 个字节，解码器不可用时返回 false
+func peekDecompressed(p *probe, c *codec, n int) ([]byte, bool) {
+	if c.reader == nil && !commandExists(c.tool) {
+		return nil, false
+	}
+	src, err := p.open()
+	if err != nil {
+		return nil, false
+	}
+	rc, err := decompress(src, c, filepath.Base(p.name), true)
+	if err != nil {
+		return nil, false
+	}
+	buf := make([]byte, n)
+	m, _ := io.ReadFull(rc, buf)
+	closeEarly(rc)
+	return buf[:m], true
+}
+
+// sniffEntry 根据条目开头的字节判断它本身是否是一个归档，魔数无法识别时退回到扩展名
+func sniffEntry(name string, head []byte) bool {
+	p := newHeadProbe(name, head)
+	for _, f := range formats {
+		if f.Detect(p) {
+			return true
+		}
+	}
+	return formatFromName(name) != nil
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== 外部工具后端（7z / unrar） ==============
+
+// externalFormat 处理标准库无法读取的格式，全部委托给 7z，rar 在缺少 7z 时可退回到 unrar
+type externalFormat struct {
+	kind       archiveKind
+	signatures []magicSignature
+	extensions []string
+	creatable  bool
+}
+
+func init() {
+	registerFormat(&externalFormat{
+		kind:       kind7z,
+		signatures: []magicSignature{{0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}}},
+		extensions: []string{".7z"},
+		creatable:  true,
+	})
+	registerFormat(&externalFormat{
+		kind: kindRar,
+		signatures: []magicSignature{
+			{0, []byte("Rar!\x1a\x07\x01\x00")}, // RAR5
+			{0, []byte("Rar!\x1a\x07\x00")},     // RAR4
+		},
+		extensions: []string{".rar"},
+	})
+	registerFormat(&externalFormat{
+		kind: kindISO,
+		signatures: []magicSignature{
+			{0x8001, []byte("CD001")},
+			{0x8801, []byte("CD001")},
+			{0x9001, []byte("CD001")},
+		},
+		extensions: []string{".iso"},
+	})
+	registerFormat(&externalFormat{
+		kind: kindCpio,
+		signatures: []magicSignature{
+			{0, []byte("070707")},   // odc
+			{0, []byte("070701")},   // newc
+			{0, []byte("070702")},   // crc
+			{0, []byte{0xc7, 0x71}}, // 小端二进制 cpio
+			{0, []byte{0x71, 0xc7}}, // 大端二进制 cpio
+		},
+		extensions: []string{".cpio"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindAr,
+		signatures: []magicSignature{{0, []byte("!<arch>\n")}},
+		extensions: []string{".ar", ".deb"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindCab,
+		signatures: []magicSignature{{0, []byte("MSCF\x00\x00\x00\x00")}},
+		extensions: []string{".cab"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindRpm,
+		signatures: []magicSignature{{0, []byte{0xed, 0xab, 0xee, 0xdb}}},
+		extensions: []string{".rpm"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindWim,
+		signatures: []magicSignature{{0, []byte("MSWIM\x00\x00\x00")}},
+		extensions: []string{".wim"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindArj,
+		signatures: []magicSignature{{0, []byte{0x60, 0xea}}},
+		extensions: []string{".arj"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindLzh,
+		signatures: []magicSignature{{2, []byte("-lh")}},
+		extensions: []string{".lzh", ".lha"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindZ,
+		signatures: []magicSignature{{0, []byte{0x1f, 0x9d}}},
+		extensions: []string{".z"},
+	})
+	registerFormat(&externalFormat{
+		kind:       kindVhd,
+		signatures: []magicSignature{{0, []byte("conectix")}},
+		extensions: []string{".vhd"},
+	})
+	// lzma 与 dmg 没有可靠的文件头魔数，只能按扩展名识别
+	registerFormat(&externalFormat{kind: kindLzma, extensions: []string{".lzma"}})
+	registerFormat(&externalFormat{kind: kindDmg, extensions: []string{".dmg"}})
+}
+
+func (x *externalFormat) Name() archiveKind { return x.kind }
+
+func (x *externalFormat) Extensions() []string { return x.extensions }
+
+func (x *externalFormat) Detect(p *probe) bool { return p.hasAnyMagic(x.signatures) }
+
+func (x *externalFormat) Capabilities() Capability {
+	caps := CapList | CapExtract
+	if x.creatable {
+		caps |= CapCreate | CapEncrypt
+	}
+	return caps
+}
+
+func (x *externalFormat) require7z(archive string) error {
+	if !commandExists("7z") {
+		return fmt.Errorf("7z command is required to handle '%s', please install p7zip", filepath.Base(archive))
+	}
+	return nil
+}
+
+func (x *externalFormat) List(archive string) ([]Entry, error) {
+	if err := x.require7z(archive); err != nil {
+		return nil, err
+	}
+	lock, err := unlock7z(archive)
+	if err != nil {
+		return nil, err
+	}
+	cmd := lock.command7z("l", "-slt", archive)
+	stderr := hidePrompts(os.Stderr)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	stderr.Close()
+	if err != nil {
+		return nil, err
+	}
+	return parse7zSlt(out), nil
+}
+
+func (x *externalFormat) Open(archive, name string) (io.ReadCloser, error) {
+	if err := x.require7z(archive); err != nil {
+		return nil, err
+	}
+	return open7zEntry(archive, name)
+}
+
+// open7zEntry 用 7z 读取单个条目，原生后端遇到加密条目时也借用它
+func open7zEntry(archive, name string) (io.ReadCloser, error) {
+	lock, err := unlock7z(archive)
+	if err != nil {
+		return nil, err
+	}
+	// 7z e -so: 把单个条目解压到标准输出
+	cmd := lock.command7z("e", "-so", archive, name)
+	stderr := hidePrompts(os.Stderr)
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReadCloser{ReadCloser: out, cmd: cmd, src: stderr}, nil
+}
+
+func (x *externalFormat) Extract(archive, dest string, opts *Options) error {
+	root, err := newExtractRoot(archive, dest, opts)
+	if err != nil {
+		return err
+	}
+
+	if x.kind == kindRar && !commandExists("7z") && commandExists("unrar") {
+		lock, err := unlockRar(archive)
+		if err != nil {
+			return err
+		}
+		names, err := lock.commandRar("lb", archive).Output()
+		if err != nil {
+			return fmt.Errorf("failed to list '%s': %v", filepath.Base(archive), err)
+		}
+		for _, name := range strings.Split(strings.TrimSpace(string(names)), "\n") {
+			if _, err := root.target(name); name != "" && err != nil {
+				return err
+			}
+		}
+		return runCmd(lock.commandRar("x", "-o+", "-y", archive, dest+string(filepath.Separator)))
+	}
+
+	// 外部工具无法逐条拦截，先读索引校验所有条目再解压
+	entries, err := x.List(archive)
+	if err != nil {
+		return err
+	}
+	if err := root.checkEntries(entries); err != nil {
+		return err
+	}
+	if err := root.checkDeclared(entries); err != nil {
+		return err
+	}
+	return extract7z(archive, dest)
+}
+
+// extract7z 用 7z 解压整个归档，调用方负责事先校验条目路径
+func extract7z(archive, dest string) error {
+	lock, err := unlock7z(archive)
+	if err != nil {
+		return err
+	}
+	// 7z x: 保持目录结构解压
+	// -y: 遇到提示自动选 yes，防止卡在终端等待输入
+	// -o: 指定输出目录（注意：-o 和路径之间没有空格）
+	return runCmd(lock.command7z("x", "-y", archive, "-o"+dest))
+}
+
+func (x *externalFormat) Create(archive, sourceDir string) error {
+	if !x.creatable {
+		return fmt.Errorf("creating %s archives is not supported", x.kind)
+	}
+	if err := x.require7z(archive); err != nil {
+		return err
+	}
+	return runCommand("7z", "a", archive, sourceDir+"/.")
+}
+
+// Build 把 adds 复制到临时目录后交给 7z 打包，7z 无法在打包时改名，只能先按归档内的路径摆好
+func (x *externalFormat) Build(archive string, adds []EditAdd, params *CreateParams) error {
+	if !x.creatable {
+		return fmt.Errorf("creating %s archives is not supported", x.kind)
+	}
+	if err := x.require7z(archive); err != nil {
+		return err
+	}
+	return build7z(archive, x.kind, adds, params)
+}
+
+// build7z 用 7z 创建 kind 类型（7z 或 zip）的归档。加密时 7z 归档同时加密文件名，
+// zip 使用 AES-256 而不是容易破解的传统 ZipCrypto
+func build7z(archive string, kind archiveKind, adds []EditAdd, params *CreateParams) error {
+	tmpdir, err := os.MkdirTemp("", "ub_build_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := stageAdds(tmpdir, adds); err != nil {
+		return err
+	}
+	args := []string{"a", "-t" + string(kind), archive, tmpdir + "/."}
+	if params.Level > 0 {
+		args = append(args, "-mx="+strconv.Itoa(params.Level))
+	}
+	if params.Threads > 0 {
+		args = append(args, "-mmt="+strconv.Itoa(params.Threads))
+	}
+	if params.Password == "" {
+		return runCommand("7z", args...)
+	}
+	// -p 不带值：由 7z 询问密码，密码经标准输入给出而不出现在命令行上
+	args = append(args, "-p")
+	if kind == kindZip {
+		args = append(args, "-mem=AES256")
+	} else {
+		args = append(args, "-mhe=on")
+	}
+	return runCmd(passwordCommand(params.Password, "7z", args...))
+}
+
+// Repack 沿用原 7z 归档的压缩方法、字典大小、固实模式与加密方式；7z 以外的格式只能按默认参数创建
+func (x *externalFormat) Repack(original, archive, sourceDir string) error {
+	if x.kind != kind7z {
+		return x.Create(archive, sourceDir)
+	}
+	if err := x.require7z(archive); err != nil {
+		return err
+	}
+	lock, err := unlock7z(original)
+	if err != nil {
+		return err
+	}
+	out, err := lock.command7z("l", "-slt", original).Output()
+	if err != nil {
+		return x.Create(archive, sourceDir)
+	}
+	args := append([]string{"a", archive, sourceDir + "/."}, sevenZipArgs(parse7zArchiveProps(out))...)
+	// 原归档加密时新归档用同一个密码加密，原来加密了文件名的继续加密文件名
+	if !lock.encrypted {
+		return runCommand("7z", args...)
+	}
+	args = append(args, "-p")
+	if lock.headers {
+		args = append(args, "-mhe=on")
+	}
+	return runCmd(passwordCommand(lock.password, "7z", args...))
+}
+
+// parse7zArchiveProps 解析 `7z l -slt` 输出中描述归档本身的部分（"----------" 分隔线之前）
+func parse7zArchiveProps(out []byte) map[string]string {
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "----------" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, " = "); ok {
+			props[key] = value
+		}
+	}
+	return props
+}
+
+// sevenZipArgs 把归档属性还原为 7z a 的参数。Method 形如 "LZMA2:24 BCJ"、"PPMD:o6:mem24" 或 "Copy"
+func sevenZipArgs(props map[string]string) []string {
+	var args []string
+	for _, m := range strings.Fields(props["Method"]) {
+		name, param, _ := strings.Cut(m, ":")
+		switch name {
+		case "7zAES", "BCJ", "BCJ2", "ARM", "ARM64", "ARMT", "PPC", "IA64", "SPARC", "Delta":
+			// 加密与可执行文件过滤器不是主压缩方法，过滤器由 7z 按文件类型自动选择
+			continue
+		case "Copy":
+			args = append(args, "-mx=0")
+		case "LZMA", "LZMA2":
+			arg := "-m0=" + name
+			if param != "" {
+				// 字典大小为 "24"（2^24 字节）或 "1536k" 形式，与 d= 参数的写法一致
+				arg += ":d=" + param
+			}
+			args = append(args, arg)
+		default:
+			args = append(args, "-m0="+name)
+		}
+		break
+	}
+	switch props["Solid"] {
+	case "+":
+		args = append(args, "-ms=on")
+	case "-":
+		args = append(args, "-ms=off")
+	}
+	return args
+}
+
+// Test 交给 `7z t` 校验全部条目
+func (x *externalFormat) Test(archive string) ([]EntryFault, error) {
+	if err := x.require7z(archive); err != nil {
+		return nil, err
+	}
+	return test7z(archive)
+}
+
+// test7z 运行 `7z t` 并从输出中找出校验失败的条目，形如 "ERROR: CRC Failed : docs/a.txt"
+func test7z(archive string) ([]EntryFault, error) {
+	if !commandExists("7z") {
+		return nil, fmt.Errorf("7z command is required to test '%s', please install p7zip", filepath.Base(archive))
+	}
+	lock, err := unlock7z(archive)
+	if err != nil {
+		return nil, err
+	}
+	out, err := lock.command7z("t", archive).CombinedOutput()
+	var faults []EntryFault
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "ERROR: ")
+		if !ok {
+			continue
+		}
+		reason, name, ok := strings.Cut(line, " : ")
+		if !ok {
+			name, reason = filepath.Base(archive), line
+		}
+		faults = append(faults, EntryFault{Name: name, Err: fmt.Errorf("%s", reason)})
+	}
+	if err != nil && len(faults) == 0 {
+		return nil, fmt.Errorf("7z reported an error: %v", err)
+	}
+	return faults, nil
+}
+
+// unlock7z 检查 archive 是否加密并取得密码。7z 在不带 -p 时会从终端读取密码，
+// 所以检查时给出空密码：文件名加密的归档会因此打不开，只加密内容的归档则在条目上标有 "Encrypted = +"
+func unlock7z(archive string) (*archiveLock, error) {
+	return passwords.unlock(archive, func() archiveLock {
+		out, err := exec.Command("7z", "l", "-slt", "-p", archive).CombinedOutput()
+		if err != nil {
+			if bytes.Contains(out, []byte("Wrong password")) || bytes.Contains(out, []byte("encrypted archive")) {
+				return archiveLock{encrypted: true, headers: true}
+			}
+			return archiveLock{}
+		}
+		return archiveLock{encrypted: bytes.Contains(out, []byte("\nEncrypted = +"))}
+	}, func(password string) bool {
+		return passwordCommand(password, "7z", "t", archive).Run() == nil
+	})
+}
+
+// unlockRar 与 unlock7z 相同，供缺少 7z 时的 unrar 使用
+func unlockRar(archive string) (*archiveLock, error) {
+	return passwords.unlock(archive, func() archiveLock {
+		out, err := exec.Command("unrar", "lt", "-p-", archive).CombinedOutput()
+		lower := bytes.ToLower(out)
+		if !bytes.Contains(lower, []byte("encrypted")) && !bytes.Contains(lower, []byte("password")) {
+			return archiveLock{}
+		}
+		return archiveLock{encrypted: true, headers: err != nil}
+	}, func(password string) bool {
+		return passwordCommand(password, "unrar", "t", archive).Run() == nil
+	})
+}
+
+// parse7zSlt 解析 `7z l -slt` 的输出。条目信息位于 "----------" 分隔线之后，每条以空行结束
+func parse7zSlt(out []byte) []Entry {
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	inEntries := false
+	var cur map[string]string
+	flush := func() {
+		if cur["Path"] != "" {
+			entries = append(entries, sltEntry(cur))
+		}
+		cur = nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !inEntries {
+			inEntries = line == "----------"
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		if cur == nil {
+			cur = make(map[string]string)
+		}
+		cur[key] = value
+	}
+	flush()
+	return entries
+}
+
+func sltEntry(kv map[string]string) Entry {
+	e := Entry{
+		Name:     filepath.ToSlash(kv["Path"]),
+		Size:     -1,
+		Packed:   -1,
+		Mode:     0644,
+		Linkname: kv["Symbolic Link"],
+	}
+	// 7z 只能列出索引，读取条目内容代价太高，嵌套归档仅按扩展名识别
+	e.IsArchive = formatFromName(e.Name) != nil
+	if n, err := strconv.ParseInt(kv["Size"], 10, 64); err == nil {
+		e.Size = n
+	}
+	if n, err := strconv.ParseInt(kv["Packed Size"], 10, 64); err == nil {
+		e.Packed = n
+	}
+	if n, err := strconv.ParseUint(kv["CRC"], 16, 32); err == nil {
+		e.CRC, e.HasCRC = uint32(n), true
+	}
+	if user, group := kv["User"], kv["Group"]; user != "" || group != "" {
+		e.Owner = user + "/" + group
+	}
+	if m := kv["Modified"]; m != "" {
+		if dot := strings.IndexByte(m, '.'); dot >= 0 {
+			m = m[:dot]
+		}
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", m, time.Local); err == nil {
+			e.ModTime = t
+		}
+	}
+
+	// Attributes 形如 "A_ -rw-r--r--" 或 "D"，带 unix 权限串时以其为准
+	attrs := kv["Attributes"]
+	if _, unix, ok := strings.Cut(attrs, " "); ok && len(unix) == 10 {
+		e.Mode = parseModeString(unix)
+	} else if kv["Folder"] == "+" || strings.HasPrefix(attrs, "D") {
+		e.Mode = os.ModeDir | 0755
+	}
+	if !e.Mode.IsRegular() {
+		e.IsArchive = false
+	}
+	return e
+}
+
+// parseModeString 把 "drwxr-xr-x" 形式的权限串转换为 FileMode
+func parseModeString(s string) os.FileMode {
+	var mode os.FileMode
+	switch s[0] {
+	case 'd':
+		mode |= os.ModeDir
+	case 'l':
+		mode |= os.ModeSymlink
+	}
+	for i, c := range s[1:10] {
+		if c != '-' {
+			mode |= 1 << uint(8-i)
+		}
+	}
+	return mode
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ============== 格式抽象与后端注册表 ==============
+
+// Capability 描述某个格式后端支持的操作
+type Capability uint
+
+const (
+	CapList Capability = 1 << iota
+	CapExtract
+	CapCreate
+	// CapNative 表示该后端完全由 Go 实现，不依赖外部命令
+	CapNative
+	// CapEncrypt 表示创建时可以用 AES-256 加密（借助 7z）
+	CapEncrypt
+)
+
+// Entry 是归档索引中的一条记录
+type Entry struct {
+	Name     string // 归档内的相对路径，统一使用 "/" 分隔，目录不带结尾 "/"
+	Size     int64  // 解压后大小，未知时为 -1
+	Packed   int64  // 压缩后大小，未知时为 -1
+	Mode     os.FileMode
+	ModTime  time.Time
+	Linkname string // 符号链接或硬链接的目标
+	Owner    string // "用户/组"，归档未记录时为空
+	Uid, Gid int    // 数字属主，只有 tar 记录
+	CRC      uint32
+	HasCRC   bool // 归档是否记录了 CRC32
+
+	// IsArchive 表示条目本身是可识别的归档（嵌套归档）。
+	// 原生后端根据条目开头的字节判断，外部工具后端只能根据扩展名判断
+	IsArchive bool
+}
+
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// entryHeadSize 是列出条目时为识别嵌套归档而读取的开头字节数
+const entryHeadSize = 512
+
+// readHead 读取条目开头的若干字节，用于 sniffEntry
+func readHead(r io.Reader) []byte {
+	head := make([]byte, entryHeadSize)
+	n, _ := io.ReadFull(r, head)
+	return head[:n]
+}
+
+// Options 是传给格式后端的运行时选项，零值即为最安全的默认行为
+type Options struct {
+	// AllowUnsafePaths 允许条目写到解压目录之外（绝对路径、".."、指向外部的链接）
+	AllowUnsafePaths bool
+	Limits           Limits
+	// KeepBackup 在改写归档（-a / -d）前把原文件保留为 .bak
+	KeepBackup bool
+	// EncryptPassword 非空时改写后的顶层归档用它加密（--encrypt）
+	EncryptPassword string
+	// VolumeSize 非零时把改写后的顶层归档拆分为分卷（--volume-size）
+	VolumeSize int64
+
+	budget *budget // 一次操作内共享的配额计数
+	inTemp bool    // 本次解压的目标是临时目录，计入临时空间配额
+}
+
+// Format 是一种归档格式的后端实现，新增格式只需实现该接口并在 init 中调用 registerFormat
+type Format interface {
+	// Name 返回格式名，同时也是该格式的惯用扩展名（不含开头的 "."）
+	Name() archiveKind
+	// Extensions 返回按文件名识别时使用的扩展名，均为小写
+	Extensions() []string
+	// Detect 根据文件头判断文件是否属于该格式
+	Detect(p *probe) bool
+	// List 只读取归档索引/头信息，不会解压条目内容
+	List(archive string) ([]Entry, error)
+	// Open 打开归档中名为 name 的单个条目
+	Open(archive, name string) (io.ReadCloser, error)
+	Extract(archive, dest string, opts *Options) error
+	// Create 将 sourceDir 下的全部内容打包为 archive
+	Create(archive, sourceDir string) error
+	Capabilities() Capability
+}
+
+// Repacker 由能够参照原归档重建的格式实现：沿用原归档的压缩方法、级别与归档选项。
+// 不支持 Editor 的格式（7z）改写归档（-a / -d）时优先使用 Repack 而不是 Create
+type Repacker interface {
+	// Repack 将 sourceDir 打包为 archive，original 是被改写的原归档
+	Repack(original, archive, sourceDir string) error
+}
+
+// Editor 由支持直接编辑的格式实现：逐条复制原归档生成新归档，不需要把整个归档解包到临时目录，
+// 耗时只与改动的条目有关
+type Editor interface {
+	// Edit 按 edit 把 original 改写为 archive
+	Edit(original, archive string, edit *ArchiveEdit) error
+}
+
+// Builder 由能够直接按条目列表创建归档的格式实现，-c 创建新归档时使用
+type Builder interface {
+	// Build 把 adds 按给定顺序写入新归档 archive
+	Build(archive string, adds []EditAdd, params *CreateParams) error
+}
+
+// Walker 由能够顺序读出全部条目的格式实现，--convert 借此边读边写，不必先把归档解包到磁盘
+type Walker interface {
+	// Walk 依次回调每个条目，r 只对普通文件有效且只能在回调内读取；
+	// 无法顺序读取的归档（如含加密条目的 zip）在回调任何条目之前返回 errNotStreamable
+	Walk(archive string, fn func(e *Entry, r io.Reader) error) error
+}
+
+// Streamer 由能够逐条写入新归档的格式实现，条目内容来自数据流而不是磁盘上的文件
+type Streamer interface {
+	NewEntryWriter(archive string, params *CreateParams) (EntryWriter, error)
+}
+
+// EntryWriter 逐条写入归档，普通文件的 Size 必须已知。Close 完成归档，出错后也要调用以释放资源
+type EntryWriter interface {
+	WriteEntry(e *Entry, r io.Reader) error
+	Close() error
+}
+
+// Tester 由能够自行校验完整性的格式实现（裸压缩流、外部工具），-t 对无法顺序读出条目的归档使用
+type Tester interface {
+	// Test 解压全部内容并丢弃，返回校验失败的条目；归档本身无法读取时返回 error
+	Test(archive string) ([]EntryFault, error)
+}
+
+// EntryFault 记录一个校验失败的条目
+type EntryFault struct {
+	Name string
+	Err  error
+}
+
+// errNotStreamable 表示归档只能解包后再读取
+var errNotStreamable = errors.New("archive cannot be read sequentially")
+
+// CreateParams 是创建归档时的压缩参数，零值表示使用格式的默认设置
+type CreateParams struct {
+	Level   int // 压缩级别，含义与各压缩工具的 -1 ~ -9 相同
+	Threads int // 压缩线程数，只对支持多线程的压缩器生效
+	// Password 非空时用 AES-256 加密，7z 同时加密文件名
+	Password string
+	// VolumeSize 非零时写完后拆分为每卷不超过该字节数的分卷
+	VolumeSize int64
+}
+
+// ArchiveEdit 描述对一个归档的改动
+type ArchiveEdit struct {
+	Deletes []string  // 要删除的条目，目录会连同其下内容一起删除
+	Adds    []EditAdd // 要加入的条目，与已有条目同名时替换之；目录只加入目录本身，其下内容需逐条给出
+}
+
+// EditAdd 把磁盘上的 Path（文件、目录或符号链接）以 Name 为路径加入归档
+type EditAdd struct {
+	Name string
+	Path string
+	// KeepMeta 只替换内容，权限、属主等沿用原归档中的同名条目（用于改写过的嵌套归档）
+	KeepMeta bool
+}
+
+// removes 判断条目 name 是否被删除
+func (e *ArchiveEdit) removes(name string) bool {
+	for _, d := range e.Deletes {
+		if name == d || strings.HasPrefix(name, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// replaces 判断条目 name 是否会被新加入的同名条目替换
+func (e *ArchiveEdit) replaces(name string) bool {
+	for _, a := range e.Adds {
+		if name == a.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanEntryName 统一条目名的写法：去掉开头的 "./" 与结尾的 "/"
+func cleanEntryName(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+}
+
+var formats []Format
+
+func registerFormat(f Format) {
+	formats = append(formats, f)
+}
+
+// lookupFormat 识别文件所属的格式：先看文件头，文件不存在或魔数无法识别时再看扩展名
+func lookupFormat(path string) (Format, error) {
+	if p, err := newProbe(path); err == nil {
+		for _, f := range formats {
+			if f.Detect(p) {
+				return f, nil
+			}
+		}
+	}
+	if f := formatFromName(path); f != nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unrecognized archive format: %s", filepath.Base(path))
+}
+
+// formatFromName 仅根据扩展名选择格式，多个扩展名同时匹配时取最长的那个（.tar.gz 优先于 .gz）
+func formatFromName(path string) Format {
+	lower := strings.ToLower(filepath.Base(path))
+	var best Format
+	bestLen := 0
+	for _, f := range formats {
+		for _, ext := range f.Extensions() {
+			if len(ext) > bestLen && strings.HasSuffix(lower, ext) {
+				best, bestLen = f, len(ext)
+			}
+		}
+	}
+	return best
+}
+
+// requireCapability 在执行操作前检查格式是否支持，避免解压到一半才发现无法写回
+func requireCapability(f Format, c Capability, action string) error {
+	if f.Capabilities()&c == 0 {
+		return fmt.Errorf("%s is not supported for %s archives", action, f.Name())
+	}
+	return nil
+}
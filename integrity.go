@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ============== 完整性测试 ==============
+
+// integrityCheck 记录一个顶层归档（含其嵌套归档）的测试结果
+type integrityCheck struct {
+	opts      *Options
+	recursive bool
+	entries   int
+	faults    []EntryFault // 名称为含嵌套链的完整位置
+}
+
+// testArchives 依次测试 files，逐个打印结果；有任何归档损坏时返回 false
+func testArchives(files []string, recursive bool, opts *Options) bool {
+	failed := 0
+	for _, file := range files {
+		opts.resetBudget()
+		c := &integrityCheck{opts: opts, recursive: recursive}
+		fmt.Printf("Testing: %s\n", file)
+		// 分卷先合并为完整的归档再测试
+		err := withVolumes(file, func(archive string) error {
+			format, err := lookupFormat(archive)
+			if err != nil {
+				return err
+			}
+			return c.check(archive, format, nil, 0)
+		})
+		for _, f := range c.faults {
+			fmt.Printf("  \033[31mCORRUPT\033[0m %s: %v\n", f.Name, f.Err)
+		}
+		switch {
+		case err != nil:
+			fmt.Printf("%s: \033[31mFAILED\033[0m (%v)\n", file, err)
+			failed++
+		case len(c.faults) > 0:
+			fmt.Printf("%s: \033[31mFAILED\033[0m (%d of %d entries corrupt)\n", file, len(c.faults), c.entries)
+			failed++
+		default:
+			fmt.Printf("%s: \033[32mOK\033[0m (%s)\n", file, plural(c.entries, "entry"))
+		}
+	}
+	if len(files) > 1 {
+		fmt.Printf("%d of %d archives passed\n", len(files)-failed, len(files))
+	}
+	return failed == 0
+}
+
+// check 测试 chain 所指的一层归档。损坏的条目记入 faults，归档本身无法读取时返回 error
+func (c *integrityCheck) check(archive string, format Format, chain []string, depth int) error {
+	if w, ok := format.(Walker); ok {
+		err := w.Walk(archive, func(e *Entry, r io.Reader) error {
+			c.entries++
+			if r != nil {
+				if err := c.readEntry(e, r, chain, depth); err != nil {
+					// 超出配额时中止整个测试，归档按失败处理
+					if isLimitError(err) {
+						return err
+					}
+					c.faults = append(c.faults, EntryFault{Name: chainKey(appendChain(chain, e.Name)), Err: err})
+				}
+			}
+			return nil
+		})
+		if err != errNotStreamable {
+			return err
+		}
+	}
+
+	var faults []EntryFault
+	var err error
+	if t, ok := format.(Tester); ok {
+		faults, err = t.Test(archive)
+	} else {
+		// 含加密条目的 zip 等原生格式只能交给 7z
+		faults, err = test7z(archive)
+	}
+	if err != nil {
+		return err
+	}
+	if entries, lerr := format.List(archive); lerr == nil {
+		c.entries += len(entries)
+	}
+	for _, f := range faults {
+		f.Name = chainKey(appendChain(chain, f.Name))
+		c.faults = append(c.faults, f)
+	}
+	return nil
+}
+
+// readEntry 把条目内容读到底以触发校验；recursive 时嵌套归档取出后继续测试，
+// 取出的临时副本计入总量与临时空间配额
+func (c *integrityCheck) readEntry(e *Entry, r io.Reader, chain []string, depth int) error {
+	if !c.recursive || !c.opts.Limits.allowsDepth(depth+1) {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+	br := bufio.NewReaderSize(r, entryHeadSize)
+	head, _ := br.Peek(entryHeadSize)
+	if !sniffEntry(e.Name, head) {
+		_, err := io.Copy(io.Discard, br)
+		return err
+	}
+
+	tmpdir, nestedFile, err := readerToTemp(br, e.Name, "ub_test_", c.opts)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	format, err := lookupFormat(nestedFile)
+	if err != nil {
+		return nil
+	}
+	return c.check(nestedFile, format, appendChain(chain, cleanEntryName(e.Name)), depth+1)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ============== 解压炸弹防护与资源配额 ==============
+
+// Limits 限制一次操作（含全部嵌套归档）能解压出的数据量，取值为 0 表示不限制
+type Limits struct {
+	MaxTotalSize int64   // 解压出的总字节数
+	MaxEntries   int     // 解压出的条目总数
+	MaxRatio     float64 // 单个归档的解压大小与归档文件大小之比
+	MaxDepth     int     // 嵌套归档的最大层数
+	MaxTempSpace int64   // 写入临时目录的累计字节数
+}
+
+func defaultLimits() Limits {
+	return Limits{
+		MaxTotalSize: 8 << 30,
+		MaxEntries:   1000000,
+		MaxRatio:     1000,
+		MaxDepth:     8,
+		MaxTempSpace: 8 << 30,
+	}
+}
+
+// allowsDepth 判断第 depth 层嵌套归档是否还允许展开
+func (l Limits) allowsDepth(depth int) bool {
+	return l.MaxDepth <= 0 || depth <= l.MaxDepth
+}
+
+// ratioFloor 以下的输出量不检查压缩比，避免把高度重复的小文件误判为炸弹
+const ratioFloor = 1 << 20
+
+// limitError 表示解压因超出配额而中止
+type limitError struct {
+	what  string
+	limit string
+	flag  string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("extraction aborted: %s exceeds the limit of %s (adjust with %s)", e.what, e.limit, e.flag)
+}
+
+func isLimitError(err error) bool {
+	var le *limitError
+	return errors.As(err, &le)
+}
+
+// budget 在一次操作的所有解压（主归档与嵌套归档）之间共享计数
+type budget struct {
+	written  int64
+	entries  int
+	tempUsed int64
+}
+
+// resetBudget 开始一次新操作前清零计数
+func (o *Options) resetBudget() {
+	o.budget = &budget{}
+}
+
+func (o *Options) tracker() *budget {
+	if o.budget == nil {
+		o.budget = &budget{}
+	}
+	return o.budget
+}
+
+// addEntry 记录新解压出的一个条目
+func (r *extractRoot) addEntry() error {
+	b := r.opts.tracker()
+	b.entries++
+	if max := r.opts.Limits.MaxEntries; max > 0 && b.entries > max {
+		return &limitError{"entry count", strconv.Itoa(max), "--max-entries"}
+	}
+	return nil
+}
+
+// charge 记录新写出的 n 个字节，并检查总量、临时空间与压缩比
+func (r *extractRoot) charge(n int64) error {
+	b := r.opts.tracker()
+	l := r.opts.Limits
+	b.written += n
+	r.written += n
+	if l.MaxTotalSize > 0 && b.written > l.MaxTotalSize {
+		return &limitError{"total uncompressed size", formatSize(l.MaxTotalSize), "--max-size"}
+	}
+	if r.opts.inTemp {
+		b.tempUsed += n
+		if l.MaxTempSpace > 0 && b.tempUsed > l.MaxTempSpace {
+			return &limitError{"temporary space usage", formatSize(l.MaxTempSpace), "--max-temp"}
+		}
+	}
+	if l.MaxRatio > 0 && r.packed > 0 && r.written > ratioFloor && float64(r.written) > float64(r.packed)*l.MaxRatio {
+		return &limitError{"compression ratio", strconv.FormatFloat(l.MaxRatio, 'f', -1, 64) + ":1", "--max-ratio"}
+	}
+	return nil
+}
+
+// checkDeclared 在交给外部工具解压前，用索引中声明的大小预先核算配额
+func (r *extractRoot) checkDeclared(entries []Entry) error {
+	for _, e := range entries {
+		if err := r.addEntry(); err != nil {
+			return err
+		}
+		if e.Size > 0 {
+			if err := r.charge(e.Size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// limitedReader 在读取的同时向配额计数
+type limitedReader struct {
+	r    io.Reader
+	root *extractRoot
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if lerr := l.root.charge(int64(n)); lerr != nil {
+			return n, lerr
+		}
+	}
+	return n, err
+}
+
+// setLimit 根据命令行选项设置对应的配额
+func setLimit(l *Limits, flag, value string) error {
+	switch flag {
+	case "--max-size", "--max-temp":
+		n, err := parseSize(value)
+		if err != nil {
+			return fmt.Errorf("option %s: %v", flag, err)
+		}
+		if flag == "--max-size" {
+			l.MaxTotalSize = n
+		} else {
+			l.MaxTempSpace = n
+		}
+	case "--max-ratio":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("option %s: invalid ratio %q", flag, value)
+		}
+		l.MaxRatio = f
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("option %s: invalid number %q", flag, value)
+		}
+		if flag == "--max-entries" {
+			l.MaxEntries = n
+		} else {
+			l.MaxDepth = n
+		}
+	}
+	return nil
+}
+
+// parseSize 解析 "512M"、"2G"、"1.5T" 这类大小，无单位时按字节计算
+func parseSize(s string) (int64, error) {
+	orig := s
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size: %q", orig)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// formatSize 以 1024 为进制输出易读的大小
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== -l 的机器可读输出 ==============
+
+// listRecord 是 --format 输出的一条记录，与 FileLocation 记录的位置信息一一对应
+type listRecord struct {
+	Number  int      `json:"number,omitempty"` // 目录没有编号
+	Archive string   `json:"archive"`          // 命令行给出的顶层归档
+	Chain   []string `json:"chain"`            // 从顶层归档到条目所在归档经过的嵌套归档路径
+	Path    string   `json:"path"`             // 条目在其所在归档中的路径
+	Type    string   `json:"type"`             // file、dir、symlink、hardlink 或 archive
+	Size    int64    `json:"size"`             // 未知时为 -1
+	Packed  int64    `json:"packed"`           // 未知时为 -1
+	Mode    string   `json:"mode,omitempty"`
+	ModTime string   `json:"mtime,omitempty"` // RFC 3339
+	Link    string   `json:"link,omitempty"`
+}
+
+var listRecordHeader = []string{"number", "archive", "chain", "path", "type", "size", "packed", "mode", "mtime", "link"}
+
+func newListRecord(archive string, chain []string, node *treeNode, number int) *listRecord {
+	r := &listRecord{
+		Number:  number,
+		Archive: archive,
+		Chain:   append([]string{}, chain...),
+		Path:    node.path,
+		Type:    "dir",
+		Size:    -1,
+		Packed:  -1,
+	}
+	e := node.entry
+	if e == nil {
+		return r
+	}
+	r.Size, r.Packed = e.Size, e.Packed
+	r.Mode = modeString(e.Mode)
+	r.Link = e.Linkname
+	if !e.ModTime.IsZero() {
+		r.ModTime = e.ModTime.Format(time.RFC3339)
+	}
+	switch {
+	case node.isDir():
+	case e.Mode&os.ModeSymlink != 0:
+		r.Type = "symlink"
+	case e.Linkname != "":
+		r.Type = "hardlink"
+	case e.IsArchive:
+		r.Type = "archive"
+	default:
+		r.Type = "file"
+	}
+	return r
+}
+
+// fields 按 listRecordHeader 的顺序返回各字段，嵌套链用 "!/" 连接
+func (r *listRecord) fields() []string {
+	number := ""
+	if r.Number > 0 {
+		number = strconv.Itoa(r.Number)
+	}
+	return []string{
+		number,
+		r.Archive,
+		strings.Join(r.Chain, "!/"),
+		r.Path,
+		r.Type,
+		strconv.FormatInt(r.Size, 10),
+		strconv.FormatInt(r.Packed, 10),
+		r.Mode,
+		r.ModTime,
+		r.Link,
+	}
+}
+
+// listWriter 输出 -l 的记录，close 在全部归档列完后调用一次
+type listWriter interface {
+	write(r *listRecord) error
+	close() error
+}
+
+// newListWriter 根据 --format 的取值创建对应的输出
+func newListWriter(format string, w io.Writer) (listWriter, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return &jsonListWriter{w: w}, nil
+	case "ndjson":
+		return &ndjsonListWriter{enc: json.NewEncoder(w)}, nil
+	case "csv", "tsv":
+		cw := csv.NewWriter(w)
+		if strings.ToLower(format) == "tsv" {
+			cw.Comma = '\t'
+		}
+		return &csvListWriter{w: cw}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (available: json, ndjson, csv, tsv)", format)
+}
+
+// jsonListWriter 逐条输出一个 JSON 数组，无需把全部记录留在内存里
+type jsonListWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonListWriter) write(r *listRecord) error {
+	data, err := json.MarshalIndent(r, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if j.count == 0 {
+		sep = "[\n  "
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, data)
+	return err
+}
+
+func (j *jsonListWriter) close() error {
+	if j.count == 0 {
+		_, err := io.WriteString(j.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+type ndjsonListWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonListWriter) write(r *listRecord) error { return n.enc.Encode(r) }
+
+func (n *ndjsonListWriter) close() error { return nil }
+
+type csvListWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvListWriter) write(r *listRecord) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(listRecordHeader); err != nil {
+			return err
+		}
+	}
+	return c.w.Write(r.fields())
+}
+
+func (c *csvListWriter) close() error {
+	if !c.header {
+		c.header = true
+		c.w.Write(listRecordHeader)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -7,28 +7,52 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // FileLocation 精确记录文件在归档中的位置，防止嵌套同名归档导致误判
 type FileLocation struct {
-	IsNested      bool
-	NestedArchive string // 嵌套归档在主归档中的相对路径
-	ItemPath      string // 文件在其所在归档中的相对路径
+	Chain    []string // 从主归档到文件所在归档依次经过的嵌套归档，每一跳都是相对上一层的路径
+	ItemPath string   // 文件在其所在归档中的相对路径
 }
 
+func (l *FileLocation) IsNested() bool { return len(l.Chain) > 0 }
+
 type Config struct {
 	deleteOrigin   bool
+	restore        bool
 	listContent    bool
 	addFiles       []string
+	create         string // -c 要创建的新归档
+	convert        bool   // --convert：把第一个归档转换为第二个
+	recursive      bool   // --convert 时展开嵌套归档，-t 时测试嵌套归档
+	testContent    bool   // -t：只校验归档完整性，不解压
+	hash           string // --hash 的摘要算法
+	manifest       string // --verify-manifest 指定的清单文件
+	verify         VerifyOptions
+	password       PasswordOptions
+	encrypt        bool  // --encrypt：新建或改写的 zip / 7z 用 AES-256 加密
+	volumeSize     int64 // --volume-size：写出的归档拆分为分卷
+	join           bool  // --join：把原始切分拼回完整文件
 	deleteContent  bool
 	extractContent bool
 	contentMap     map[int]*FileLocation
 	currentNumber  int
+	layout         *listLayout // -l 的元数据列，nil 为紧凑视图
+	output         listWriter  // -l 的机器可读输出，nil 时打印树
+	listing        string      // 正在列出的顶层归档
+	quiet          bool        // 只建立编号不打印，用于命令行选择条目
+	selectors      []string    // -e / -d 在归档之后给出的条目选择器
+	selectFrom     string      // --select-from 指定的选择器文件
+	add            AddOptions  // -a / -c 的目标路径与过滤规则
+	params         CreateParams
+	options        Options
 }
 
 func main() {
@@ -36,6 +60,8 @@ func main() {
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sig
+		restoreTerminal()
+		removePendingFiles()
 		fmt.Print("\033[0m")
 		os.Exit(1)
 	}()
@@ -43,6 +69,7 @@ func main() {
 	config := &Config{
 		contentMap:    make(map[int]*FileLocation),
 		currentNumber: 1,
+		options:       Options{Limits: defaultLimits()},
 	}
 
 	args := os.Args[1:]
@@ -57,31 +84,205 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := passwords.configure(&config.password); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if config.output != nil && !config.listContent {
+		fmt.Fprintln(os.Stderr, "Error: --format can only be used with -l")
+		os.Exit(1)
+	}
+
+	if config.add.set() && len(config.addFiles) == 0 && config.create == "" {
+		fmt.Fprintln(os.Stderr, "Error: --into, --parents, --include and --exclude can only be used with -a or -c")
+		os.Exit(1)
+	}
+
+	if config.params != (CreateParams{}) && config.create == "" && !config.convert {
+		fmt.Fprintln(os.Stderr, "Error: --level and --threads can only be used with -c or --convert")
+		os.Exit(1)
+	}
+
+	if config.encrypt {
+		if config.create == "" && !config.convert && len(config.addFiles) == 0 && !config.deleteContent {
+			fmt.Fprintln(os.Stderr, "Error: --encrypt can only be used with -c, --convert, -a or -d")
+			os.Exit(1)
+		}
+		password, err := passwords.encryptionKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		config.params.Password = password
+		config.options.EncryptPassword = password
+	}
+
+	if config.volumeSize > 0 {
+		if config.create == "" && !config.convert && len(config.addFiles) == 0 && !config.deleteContent {
+			fmt.Fprintln(os.Stderr, "Error: --volume-size can only be used with -c, --convert, -a or -d")
+			os.Exit(1)
+		}
+		config.params.VolumeSize = config.volumeSize
+		config.options.VolumeSize = config.volumeSize
+	}
+
+	if config.verify.TrustedKeys != "" && !config.verify.Enabled {
+		fmt.Fprintln(os.Stderr, "Error: --trusted-keys requires --verify or --require-verified")
+		os.Exit(1)
+	}
+
+	if config.verify.Enabled && (config.restore || config.create != "" || config.convert || config.testContent || config.hash != "" || config.manifest != "" ||
+		config.listContent || config.deleteContent || config.extractContent || len(config.addFiles) > 0) {
+		fmt.Fprintln(os.Stderr, "Error: --verify and --require-verified only apply when extracting whole archives")
+		os.Exit(1)
+	}
+
+	if config.recursive && !config.convert && !config.testContent {
+		fmt.Fprintln(os.Stderr, "Error: --recursive can only be used with --convert or -t")
+		os.Exit(1)
+	}
+
 	if len(files) == 0 {
 		fmt.Fprintln(os.Stderr, "Error: No input files specified")
 		showHelp()
 		os.Exit(1)
 	}
 
+	// 0. Handle Restore mode (--restore)
+	if config.restore {
+		failed := false
+		for _, file := range files {
+			if err := restoreBackup(file); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Restored %s from %s%s\n", file, file, backupSuffix)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 0. Handle Join mode (--join)
+	if config.join {
+		if len(files) > 2 || config.create != "" || config.convert || len(config.addFiles) > 0 || config.deleteOrigin || config.listContent || config.deleteContent || config.extractContent || config.testContent {
+			fmt.Fprintln(os.Stderr, "Error: --join takes one volume and an optional output file")
+			os.Exit(1)
+		}
+		out := ""
+		if len(files) == 2 {
+			out = files[1]
+		}
+		if err := joinVolumes(files[0], out); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Volumes joined successfully")
+		return
+	}
+
+	// 0. Handle Create mode (-c)
+	if config.create != "" {
+		if len(config.addFiles) > 0 || config.deleteOrigin || config.listContent || config.deleteContent || config.extractContent {
+			fmt.Fprintln(os.Stderr, "Error: -c option can only be used alone")
+			os.Exit(1)
+		}
+		if err := createArchive(config.create, files, &config.add, &config.params); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Archive created successfully")
+		return
+	}
+
+	// 0. Handle Convert mode (--convert)
+	if config.convert {
+		if len(files) != 2 || config.create != "" || len(config.addFiles) > 0 || config.deleteOrigin || config.listContent || config.deleteContent || config.extractContent {
+			fmt.Fprintln(os.Stderr, "Error: --convert requires exactly one input and one output archive")
+			os.Exit(1)
+		}
+		if err := convertArchive(files[0], files[1], config.recursive, &config.params, &config.options); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Archive converted successfully")
+		return
+	}
+
+	// 0. Handle Test mode (-t)
+	if config.testContent {
+		if config.create != "" || len(config.addFiles) > 0 || config.deleteOrigin || config.listContent || config.deleteContent || config.extractContent {
+			fmt.Fprintln(os.Stderr, "Error: -t option can only be used alone")
+			os.Exit(1)
+		}
+		if !testArchives(files, config.recursive, &config.options) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 0. Handle Hash / Manifest mode (--hash, --verify-manifest)
+	if config.hash != "" || config.manifest != "" {
+		if config.create != "" || len(config.addFiles) > 0 || config.deleteOrigin || config.listContent || config.deleteContent || config.extractContent || config.testContent {
+			fmt.Fprintln(os.Stderr, "Error: --hash and --verify-manifest can only be used alone")
+			os.Exit(1)
+		}
+		if config.manifest == "" {
+			if !printManifest(files, config.hash, &config.options) {
+				os.Exit(1)
+			}
+			return
+		}
+		if len(files) != 1 {
+			fmt.Fprintln(os.Stderr, "Error: --verify-manifest requires exactly one archive file")
+			os.Exit(1)
+		}
+		ok, err := verifyManifest(files[0], config.manifest, config.hash, &config.options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 1. Handle List mode (-l)
 	if config.listContent {
+		failed := false
 		for _, file := range files {
-			fmt.Println("----------------------------------")
-			fmt.Printf("Contents of %s:\n", file)
+			config.treef("----------------------------------\n")
+			config.treef("Contents of %s:\n", file)
 			if err := processList(file, config); err != nil {
 				fmt.Fprintf(os.Stderr, "Error listing %s: %v\n", file, err)
+				failed = true
 			}
-			fmt.Println("----------------------------------")
+			config.treef("----------------------------------\n")
+		}
+		if config.output != nil {
+			if err := config.output.close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
 		}
 		return
 	}
 
 	// 2. Handle Delete content mode (-d)
 	if config.deleteContent {
-		if len(files) != 1 || len(config.addFiles) > 0 || config.deleteOrigin {
+		if len(config.addFiles) > 0 || config.deleteOrigin {
 			fmt.Fprintln(os.Stderr, "Error: -d option can only be used alone with exactly one archive file")
 			os.Exit(1)
 		}
+		// 归档之后的参数都是条目选择器
+		config.selectors = files[1:]
 		if err := processDelete(files[0], config); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
@@ -91,10 +292,12 @@ func main() {
 
 	// 3. Handle Extract content mode (-e)
 	if config.extractContent {
-		if len(files) != 1 || len(config.addFiles) > 0 || config.deleteOrigin {
+		if len(config.addFiles) > 0 || config.deleteOrigin {
 			fmt.Fprintln(os.Stderr, "Error: -e option can only be used alone with exactly one archive file")
 			os.Exit(1)
 		}
+		// 归档之后的参数都是条目选择器
+		config.selectors = files[1:]
 		if err := processExtract(files[0], config); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
@@ -112,7 +315,7 @@ func main() {
 			fmt.Fprintln(os.Stderr, "Error: Add files mode cannot be used with -o options")
 			os.Exit(1)
 		}
-		if err := addFilesToArchive(files[0], config.addFiles); err != nil {
+		if err := addFilesToArchive(files[0], config.addFiles, &config.add, &config.options); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -120,13 +323,19 @@ func main() {
 	}
 
 	// 5. Default: Process all files (Extract all)
-	for _, file := range files {
+	// 同一组分卷只从第一卷解压一次
+	failed := false
+	for _, set := range groupVolumes(files) {
 		fmt.Println("----------------------------------")
-		if err := processFile(file, config); err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", file, err)
+		if err := processFile(set, config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", set.name, err)
+			failed = true
 		}
 		fmt.Println("----------------------------------")
 	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
 func showHelp() {
@@ -134,19 +343,75 @@ func showHelp() {
 	fmt.Print(`
 ` + "\033[96m" + `Options:` + "\033[0m" + `
     ` + "\033[32m" + `-o` + "\033[0m" + `      Delete original archive after successful extraction.
-    ` + "\033[32m" + `-e` + "\033[0m" + `      Extract specific file from the archive.
+    ` + "\033[32m" + `-e` + "\033[0m" + `      Extract specific file from the archive (entries may follow the archive).
     ` + "\033[32m" + `-l` + "\033[0m" + `      Display the contents of the archive.
-    ` + "\033[32m" + `-a` + "\033[0m" + `      Add files to the archive.
-    ` + "\033[32m" + `-d` + "\033[0m" + `      Delete file from the archive.
+    ` + "\033[32m" + `-t` + "\033[0m" + `      Test archive integrity (CRCs and checksums) without extracting.
+    ` + "\033[32m" + `-a` + "\033[0m" + `      Add files or directories (recursively) to the archive.
+    ` + "\033[32m" + `-d` + "\033[0m" + `      Delete file from the archive (entries may follow the archive).
+    ` + "\033[32m" + `-c` + "\033[0m" + `      Create a new archive from files and directories (format from its name).
     ` + "\033[32m" + `-h` + "\033[0m" + `      Show this help message.
     ` + "\033[32m" + `-v` + "\033[0m" + `      Show version and license information.
+    ` + "\033[32m" + `--long` + "\033[0m" + `, ` + "\033[32m" + `--columns LIST` + "\033[0m" + `
+            Show metadata columns with -l (mode,owner,size,packed,ratio,mtime,crc,link or all).
+    ` + "\033[32m" + `--format FMT` + "\033[0m" + `
+            Print the -l listing as json, ndjson, csv or tsv instead of a tree.
+    ` + "\033[32m" + `--select-from FILE` + "\033[0m" + `
+            Read -e / -d selectors from FILE, one per line ("-" for stdin).
+    ` + "\033[32m" + `--into PATH` + "\033[0m" + `
+            Place -a files under PATH inside the archive instead of at its root
+            ("inner.zip!/lib/" adds into a nested archive, replacing same-named entries).
+    ` + "\033[32m" + `--parents` + "\033[0m" + ` Keep the relative path given to -a (src/util/x.go) instead of the base name.
+    ` + "\033[32m" + `--include GLOB` + "\033[0m" + `, ` + "\033[32m" + `--exclude GLOB` + "\033[0m" + `
+            Filter what -a / -c pick up from directories (repeatable, matches name or path).
+    ` + "\033[32m" + `--level N` + "\033[0m" + `, ` + "\033[32m" + `--threads N` + "\033[0m" + `
+            Compression level and compressor threads for -c / --convert (xz / zstd / 7z use threads).
+    ` + "\033[32m" + `--convert IN OUT` + "\033[0m" + `
+            Convert IN into a new archive OUT (format from its name), streaming entries when possible.
+    ` + "\033[32m" + `--hash ALG` + "\033[0m" + `
+            Print a sha256sum-style manifest of every entry (sha256, sha1, md5 or blake2b).
+    ` + "\033[32m" + `--verify-manifest FILE` + "\033[0m" + `
+            Check an archive's entries against a manifest written by --hash or sha256sum.
+    ` + "\033[32m" + `--recursive` + "\033[0m" + `
+            With --convert, expand nested archives into directories of the same name;
+            with -t, test nested archives too.
+    ` + "\033[32m" + `--backup` + "\033[0m" + `  Keep the original archive as ARCHIVE.bak when -a / -d rewrites it.
+    ` + "\033[32m" + `--restore` + "\033[0m" + ` Roll an archive back to its ARCHIVE.bak copy.
+    ` + "\033[32m" + `--verify` + "\033[0m" + `, ` + "\033[32m" + `--require-verified` + "\033[0m" + `
+            Check ARCHIVE.sha256 / SHA256SUMS / ARCHIVE.minisig before extracting;
+            --require-verified refuses archives without a passing checksum or signature.
+    ` + "\033[32m" + `--trusted-keys FILE` + "\033[0m" + `
+            Minisign / ed25519 public keys to accept (default ~/.config/unbox/trusted-keys).
+    ` + "\033[32m" + `--password PW` + "\033[0m" + `, ` + "\033[32m" + `--password-file FILE` + "\033[0m" + `
+            Password for encrypted zip / 7z / rar archives (also UNBOX_PASSWORD);
+            without one, unbox asks on the terminal when it meets an encrypted archive.
+    ` + "\033[32m" + `--password-list FILE` + "\033[0m" + `
+            Try each line of FILE as a password until one opens the archive.
+    ` + "\033[32m" + `--encrypt` + "\033[0m" + ` Encrypt the zip / 7z written by -c, --convert, -a or -d with AES-256
+            (7z file names too), using the password above or one typed twice.
+    ` + "\033[32m" + `--volume-size SIZE` + "\033[0m" + `
+            Split the archive written by -c, --convert, -a or -d into volumes of SIZE
+            (foo.7z.001, foo.z01 + foo.zip, or foo.tar.gz.aa for tar streams).
+    ` + "\033[32m" + `--join PART [OUT]` + "\033[0m" + `
+            Concatenate raw split volumes (foo.tar.gz.aa, foo.7z.001 ...) back into one file.
+    ` + "\033[32m" + `--allow-unsafe-paths` + "\033[0m" + `
+            Allow entries to be written outside the destination directory.
+    ` + "\033[32m" + `--max-size SIZE` + "\033[0m" + `, ` + "\033[32m" + `--max-temp SIZE` + "\033[0m" + `
+            Limit total uncompressed bytes / temporary space (default 8G, 0 = unlimited).
+    ` + "\033[32m" + `--max-entries N` + "\033[0m" + `, ` + "\033[32m" + `--max-ratio N` + "\033[0m" + `, ` + "\033[32m" + `--max-depth N` + "\033[0m" + `
+            Limit entry count (1000000), compression ratio (1000) and nesting depth (8).
 
 ` + "\033[96m" + `Examples:` + "\033[0m" + `
 	` + "\033[93m" + `unbox -o *.zip *.tar.gz` + "\033[0m" + `
 	` + "\033[93m" + `unbox -e archive.zip` + "\033[0m" + `
+	` + "\033[93m" + `unbox -e archive.zip 'docs/*.md' 3 7-12 'inner.zip!/lib/*'` + "\033[0m" + `
 	` + "\033[93m" + `unbox -l archive.zip` + "\033[0m" + `
+	` + "\033[93m" + `unbox -t --recursive *.zip *.tar.gz` + "\033[0m" + `
+	` + "\033[93m" + `unbox --hash sha256 release.tar.gz > SHA256SUMS` + "\033[0m" + `
 	` + "\033[93m" + `unbox -a file archive.zip` + "\033[0m" + `
+	` + "\033[93m" + `unbox -a src --into lib/ --exclude '*.o' archive.tar.gz` + "\033[0m" + `
 	` + "\033[93m" + `unbox -d archive.zip` + "\033[0m" + `
+	` + "\033[93m" + `unbox -c out.tar.zst --level 19 --threads 4 dir1 file2` + "\033[0m" + `
+	` + "\033[93m" + `unbox --convert vendor.rar vendor.tar.zst` + "\033[0m" + `
 
 `)
 }
@@ -217,6 +482,8 @@ func parseArgs(args []string, config *Config) ([]string, error) {
 			config.extractContent = true
 		case "-l":
 			config.listContent = true
+		case "-t":
+			config.testContent = true
 		case "-d":
 			config.deleteContent = true
 		case "-h":
@@ -226,6 +493,138 @@ func parseArgs(args []string, config *Config) ([]string, error) {
 			coloredVersion := addGradient(versionText, [3]int{210, 58, 68}, [3]int{221, 155, 85})
 			fmt.Println(coloredVersion)
 			os.Exit(0)
+		case "--long":
+			config.layout, _ = parseColumns(defaultLongColumns)
+		case "--columns":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			layout, err := parseColumns(args[i])
+			if err != nil {
+				return nil, err
+			}
+			config.layout = layout
+		case "--format":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			output, err := newListWriter(args[i], os.Stdout)
+			if err != nil {
+				return nil, err
+			}
+			config.output = output
+		case "--select-from":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			config.selectFrom = args[i]
+		case "--into", "--include", "--exclude":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			switch arg {
+			case "--into":
+				config.add.Into = args[i]
+			case "--include":
+				config.add.Include = append(config.add.Include, args[i])
+			case "--exclude":
+				config.add.Exclude = append(config.add.Exclude, args[i])
+			}
+		case "--level", "--threads":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid value for %s: %s", arg, args[i])
+			}
+			if arg == "--level" {
+				config.params.Level = n
+			} else {
+				config.params.Threads = n
+			}
+		case "--hash", "--verify-manifest":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			if arg == "--hash" {
+				if _, ok := hashAlgorithms[args[i]]; !ok {
+					return nil, fmt.Errorf("unknown hash algorithm '%s' (use sha256, sha1, md5 or blake2b)", args[i])
+				}
+				config.hash = args[i]
+			} else {
+				config.manifest = args[i]
+			}
+		case "--verify":
+			config.verify.Enabled = true
+		case "--require-verified":
+			config.verify.Enabled = true
+			config.verify.Require = true
+		case "--password", "--password-file", "--password-list":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			switch arg {
+			case "--password":
+				config.password.Password = args[i]
+			case "--password-file":
+				config.password.File = args[i]
+			case "--password-list":
+				config.password.List = args[i]
+			}
+		case "--encrypt":
+			config.encrypt = true
+		case "--join":
+			config.join = true
+		case "--volume-size":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			n, err := parseSize(args[i])
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid value for %s: %s", arg, args[i])
+			}
+			config.volumeSize = n
+		case "--trusted-keys":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			config.verify.TrustedKeys = args[i]
+		case "--convert":
+			config.convert = true
+		case "--recursive":
+			config.recursive = true
+		case "--parents":
+			config.add.Parents = true
+		case "--backup":
+			config.options.KeepBackup = true
+		case "--restore":
+			config.restore = true
+		case "--allow-unsafe-paths":
+			config.options.AllowUnsafePaths = true
+		case "--max-size", "--max-temp", "--max-entries", "--max-ratio", "--max-depth":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", arg)
+			}
+			i++
+			if err := setLimit(&config.options.Limits, arg, args[i]); err != nil {
+				return nil, err
+			}
+		case "-c":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option -c requires an argument")
+			}
+			i++
+			config.create = args[i]
 		case "-a":
 			if i+1 >= len(args) {
 				return nil, fmt.Errorf("option -a requires an argument")
@@ -245,52 +644,16 @@ func commandExists(cmd string) bool {
 	return err == nil
 }
 
-func isCompressedFile(filename string) bool {
-	extensions := []string{
-		".tar.bz2", ".tbz2", ".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.zst", ".tzst",
-		".bz2", ".rar", ".gz", ".tar", ".zip", ".Z", ".7z", ".xz", ".lzma", ".zst",
-		".cab", ".iso", ".arj", ".lzh", ".cpio", ".rpm", ".deb", ".dmg", ".wim", ".vhd",
-	}
-	filename = strings.ToLower(filename)
-	for _, ext := range extensions {
-		if strings.HasSuffix(filename, ext) {
-			return true
-		}
-	}
-	return false
-}
-
-func extractArchive(file, dest string) error {
+func extractArchive(file, dest string, opts *Options) error {
 	if dest == "" {
 		dest = "."
 	}
 
-	// 优先检查是否安装了 7z，因为我们将把它作为主要的解压引擎
-	has7z := commandExists("7z")
-
-	switch {
-	// 1. Tar 家族：原生 tar 命令支持一步解压到底，体验最好（7z 解压 tar.gz 需要两步）
-	case strings.HasSuffix(file, ".tar.bz2") || strings.HasSuffix(file, ".tbz2"):
-		return runCommand("tar", "xjf", file, "-C", dest)
-	case strings.HasSuffix(file, ".tar.gz") || strings.HasSuffix(file, ".tgz"):
-		return runCommand("tar", "xzf", file, "-C", dest)
-	case strings.HasSuffix(file, ".tar.xz") || strings.HasSuffix(file, ".txz"):
-		return runCommand("tar", "xJf", file, "-C", dest)
-	case strings.HasSuffix(file, ".tar.zst") || strings.HasSuffix(file, ".tzst"):
-		return runCommand("tar", "--zstd", "-xf", file, "-C", dest)
-	case strings.HasSuffix(file, ".tar"):
-		return runCommand("tar", "xf", file, "-C", dest)
-
-	// 2. 其他所有格式：统统交给 7z
-	default:
-		if !has7z {
-			return fmt.Errorf("7z command is required to extract '%s', please install p7zip", filepath.Base(file))
-		}
-		// 7z x: 保持目录结构解压
-		// -y: 遇到提示自动选 yes，防止卡在终端等待输入
-		// -o: 指定输出目录（注意：-o 和路径之间没有空格）
-		return runCommand("7z", "x", "-y", file, "-o"+dest)
+	format, err := lookupFormat(file)
+	if err != nil {
+		return err
 	}
+	return format.Extract(file, dest, opts)
 }
 
 func runCommand(name string, args ...string) error {
@@ -301,54 +664,172 @@ func runCommand(name string, args ...string) error {
 	return cmd.Run()
 }
 
-func runCommandInDir(dir, name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Dir = dir
+// runCmd 与 runCommand 相同，用于已构造好的命令（如经标准输入提供密码的 7z），错误输出中不显示询问密码的提示
+func runCmd(cmd *exec.Cmd) error {
+	stderr := hidePrompts(os.Stderr)
 	cmd.Stdout = io.Discard
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stderr.Close()
+	return err
 }
 
+// copyFile 复制文件并保留权限位与修改时间；src 是符号链接时复制链接本身而不是它指向的内容
 func copyFile(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return writeSymlink(dst, link)
+	}
+
 	srcFile, err := os.Open(src)
-	if err != nil { return err }
+	if err != nil {
+		return err
+	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
-	if err != nil { return err }
-	defer dstFile.Close()
+	// 目标若是符号链接或只读文件，先删掉它，避免顺着链接写到别处
+	os.Remove(dst)
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+	// 创建文件时权限会被 umask 削减，这里按源文件重新设置
+	os.Chmod(dst, fi.Mode().Perm())
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
 }
 
 func createTempDir(prefix string) (string, error) {
 	return os.MkdirTemp("", prefix)
 }
 
-// ============== 核心：统一的树状遍历引擎 ==============
-func buildArchiveTree(currentExtractDir string, currentRelPath string, prefix string, config *Config, nestedArchivePath string) error {
-	entries, err := os.ReadDir(currentExtractDir)
+// entryToTemp 只把归档中的单个条目取出到新建的临时目录，写入量计入临时空间配额
+func entryToTemp(format Format, archive, name, prefix string, opts *Options) (string, string, error) {
+	rc, err := format.Open(archive, name)
 	if err != nil {
-		return err
+		return "", "", err
 	}
+	defer rc.Close()
+	return readerToTemp(rc, name, prefix, opts)
+}
 
-	sort.Slice(entries, func(i, j int) bool {
-		if entries[i].IsDir() != entries[j].IsDir() {
-			return entries[i].IsDir()
-		}
-		return entries[i].Name() < entries[j].Name()
-	})
+// readerToTemp 把条目数据写到新建临时目录中的同名文件，写入量计入总量与临时空间配额
+func readerToTemp(r io.Reader, name, prefix string, opts *Options) (string, string, error) {
+	dir, err := createTempDir(prefix)
+	if err != nil {
+		return "", "", err
+	}
+	opts.tracker()
+	tmpOpts := *opts
+	tmpOpts.inTemp = true
+
+	root, err := newExtractRoot("", dir, &tmpOpts)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	target := filepath.Join(dir, path.Base(name))
+	if err := root.writeFile(target, r, 0600, time.Time{}); err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, target, nil
+}
 
-	numItems := len(entries)
-	for i, entry := range entries {
-		itemName := entry.Name()
-		fullPath := filepath.Join(currentExtractDir, itemName)
+// extractToTemp 把归档解压到新建的临时目录，写入量计入临时空间配额；失败时不留下临时目录
+func extractToTemp(file, prefix string, opts *Options) (string, error) {
+	dir, err := createTempDir(prefix)
+	if err != nil {
+		return "", err
+	}
+	opts.tracker()
+	tmpOpts := *opts
+	tmpOpts.inTemp = true
+	if err := extractArchive(file, dir, &tmpOpts); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// ============== 核心：统一的树状遍历引擎 ==============
+
+// treeNode 是根据归档索引重建出的目录树节点
+type treeNode struct {
+	name     string
+	path     string // 在所属归档中的相对路径
+	entry    *Entry // tar 等格式常省略目录条目，这类目录节点的 entry 为 nil
+	children map[string]*treeNode
+}
 
-		itemRelPath := itemName
-		if currentRelPath != "" {
-			itemRelPath = filepath.Join(currentRelPath, itemName)
+func (n *treeNode) isDir() bool {
+	return len(n.children) > 0 || n.entry == nil || n.entry.IsDir()
+}
+
+// newTree 把扁平的条目列表组装成目录树，同名条目以后出现的为准
+func newTree(entries []Entry) *treeNode {
+	root := &treeNode{children: make(map[string]*treeNode)}
+	for i := range entries {
+		node := root
+		parts := strings.Split(strings.Trim(entries[i].Name, "/"), "/")
+		for depth, part := range parts {
+			if part == "" || part == "." {
+				continue
+			}
+			child, ok := node.children[part]
+			if !ok {
+				child = &treeNode{
+					name:     part,
+					path:     strings.Join(parts[:depth+1], "/"),
+					children: make(map[string]*treeNode),
+				}
+				node.children[part] = child
+			}
+			node = child
+		}
+		if node != root {
+			node.entry = &entries[i]
 		}
+	}
+	return root
+}
+
+// sortedChildren 目录在前，同类按名称排序
+func (n *treeNode) sortedChildren() []*treeNode {
+	nodes := make([]*treeNode, 0, len(n.children))
+	for _, c := range n.children {
+		nodes = append(nodes, c)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].isDir() != nodes[j].isDir() {
+			return nodes[i].isDir()
+		}
+		return nodes[i].name < nodes[j].name
+	})
+	return nodes
+}
+
+func buildArchiveTree(node *treeNode, prefix string, config *Config, level *archiveLevel) error {
+	layout := config.layout
+	children := node.sortedChildren()
+	numItems := len(children)
+	for i, child := range children {
+		itemName := child.name
+		itemRelPath := child.path
 
 		var linePrefix, newPrefix string
 		if i == numItems-1 {
@@ -359,32 +840,64 @@ func buildArchiveTree(currentExtractDir string, currentRelPath string, prefix st
 			newPrefix = prefix + "│   "
 		}
 
-		if entry.IsDir() {
-			fmt.Printf("\033[90m%s\033[34m%s/\033[0m\n", linePrefix, itemName)
-			buildArchiveTree(fullPath, itemRelPath, newPrefix, config, nestedArchivePath)
+		number := 0
+		if !child.isDir() {
+			number = config.currentNumber
+		}
+		if config.output != nil {
+			if err := config.output.write(newListRecord(config.listing, level.chain, child, number)); err != nil {
+				return err
+			}
+		}
+
+		if child.isDir() {
+			config.treef("%s\033[90m%s\033[34m%s/\033[0m\n", layout.cells(child.entry), linePrefix, itemName)
+			if err := buildArchiveTree(child, newPrefix, config, level); err != nil {
+				return err
+			}
 		} else {
-			isNestedArchive := isCompressedFile(itemName)
+			isNestedArchive := child.entry.IsArchive
+			depth := len(level.chain) + 1
 
 			loc := &FileLocation{
-				IsNested:      nestedArchivePath != "",
-				NestedArchive: nestedArchivePath,
-				ItemPath:      itemRelPath,
+				Chain:    level.chain,
+				ItemPath: itemRelPath,
 			}
 			config.contentMap[config.currentNumber] = loc
 
-			if isNestedArchive && nestedArchivePath == "" {
-				fmt.Printf("\033[90m%s\033[0m%d) \033[36m%s\033[0m [Nested Archive]\n", linePrefix, config.currentNumber, itemName)
+			if isNestedArchive && !config.options.Limits.allowsDepth(depth) {
+				config.treef("%s\033[90m%s\033[0m%d) \033[36m%s\033[0m [Nested Archive, depth limit reached]\n", layout.cells(child.entry), linePrefix, config.currentNumber, itemName)
+				config.currentNumber++
+			} else if isNestedArchive {
+				config.treef("%s\033[90m%s\033[0m%d) \033[36m%s\033[0m [Nested Archive]\n", layout.cells(child.entry), linePrefix, config.currentNumber, itemName)
 				config.currentNumber++
 
-				nestedTmp, err := createTempDir("ub_nest_")
-				if err == nil {
-					if extractArchive(fullPath, nestedTmp) == nil {
-						buildArchiveTree(nestedTmp, "", newPrefix, config, itemRelPath)
+				// 只把这一个嵌套归档取出到临时文件，再读取它的索引
+				err := listNested(level.format, level.file, itemRelPath, func(nestedFile string, nestedFormat Format, entries []Entry) error {
+					id, err := fileDigest(nestedFile)
+					if err != nil {
+						return err
 					}
-					os.RemoveAll(nestedTmp)
+					if level.seen(id) {
+						config.treef("%s\033[90m%s╰─ (loop: same content as an enclosing archive)\033[0m\n", layout.cells(nil), newPrefix)
+						return nil
+					}
+					if err := buildArchiveTree(newTree(entries), newPrefix, config, level.child(itemRelPath, nestedFile, nestedFormat, id)); err != nil {
+						return err
+					}
+					if layout != nil {
+						config.treef("%s\033[90m%s%s\033[0m\n", layout.cells(nil), newPrefix, totalsOf(nestedFile, entries))
+					}
+					return nil
+				}, &config.options)
+				if isLimitError(err) {
+					return err
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: cannot open nested archive %s: %v\n", chainKey(appendChain(level.chain, itemRelPath)), err)
 				}
 			} else {
-				fmt.Printf("\033[90m%s\033[0m%d) %s\n", linePrefix, config.currentNumber, itemName)
+				config.treef("%s\033[90m%s\033[0m%d) %s%s\n", layout.cells(child.entry), linePrefix, config.currentNumber, itemName, layout.linkSuffix(child.entry))
 				config.currentNumber++
 			}
 		}
@@ -392,285 +905,280 @@ func buildArchiveTree(currentExtractDir string, currentRelPath string, prefix st
 	return nil
 }
 
-func processList(archive string, config *Config) error {
-	config.contentMap = make(map[int]*FileLocation)
-	config.currentNumber = 1
+// treef 只在打印树形视图时输出，--format 模式下保持标准输出为纯数据，quiet 时不输出
+func (c *Config) treef(format string, args ...interface{}) {
+	if c.output == nil && !c.quiet {
+		fmt.Printf(format, args...)
+	}
+}
 
-	tmpdir, err := createTempDir("ub_list_")
+// listNested 把嵌套归档单独取出到临时目录并读取其索引，回调返回后临时文件即被删除
+func listNested(format Format, archive, name string, fn func(nestedFile string, nestedFormat Format, entries []Entry) error, opts *Options) error {
+	tmpdir, nestedFile, err := entryToTemp(format, archive, name, "ub_nest_", opts)
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tmpdir)
 
-	if err := extractArchive(archive, tmpdir); err != nil {
-		return fmt.Errorf("extraction failed: %v", err)
-	}
-
-	return buildArchiveTree(tmpdir, "", "", config, "")
-}
-
-// ============== Delete 逻辑 ==============
-func processDelete(archive string, config *Config) error {
-	fmt.Println("Listing archive contents:")
-	if err := processList(archive, config); err != nil {
+	nestedFormat, err := lookupFormat(nestedFile)
+	if err != nil {
 		return err
 	}
-
-	if len(config.contentMap) == 0 {
-		fmt.Println("Archive is empty, no content to delete")
-		return nil
+	if err := requireCapability(nestedFormat, CapList, "listing"); err != nil {
+		return err
 	}
-
-	fmt.Print("Enter the number(s) to delete (space separated): ")
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
+	entries, err := nestedFormat.List(nestedFile)
 	if err != nil {
 		return err
 	}
+	return fn(nestedFile, nestedFormat, entries)
+}
 
-	numbers := strings.Fields(input)
-	var filesToDelete []*FileLocation
+func processList(archive string, config *Config) error {
+	config.contentMap = make(map[int]*FileLocation)
+	config.currentNumber = 1
+	config.options.resetBudget()
 
-	for _, numStr := range numbers {
-		num, err := strconv.Atoi(numStr)
+	// 分卷先合并为完整的归档再读取，输出中仍使用命令行给出的名称
+	return withVolumes(archive, func(whole string) error {
+		format, err := lookupFormat(whole)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: '%s' is invalid, skipping\n", numStr)
-			continue
+			return err
 		}
-		if entry, exists := config.contentMap[num]; exists {
-			filesToDelete = append(filesToDelete, entry)
-		} else {
-			fmt.Fprintf(os.Stderr, "Warning: number '%d' does not exist\n", num)
+		if err := requireCapability(format, CapList, "listing"); err != nil {
+			return err
+		}
+		entries, err := format.List(whole)
+		if err != nil {
+			return fmt.Errorf("failed to read archive index: %w", err)
 		}
-	}
 
-	if len(filesToDelete) == 0 {
-		fmt.Println("No valid files to delete")
+		config.listing = archive
+		if config.layout != nil {
+			config.treef("\033[90m%s\033[0m\n", config.layout.header())
+		}
+		if err := buildArchiveTree(newTree(entries), "", config, &archiveLevel{file: whole, format: format}); err != nil {
+			return err
+		}
+		if config.layout != nil {
+			config.treef("\033[90m%s\033[0m\n", totalsOf(whole, entries))
+		}
 		return nil
-	}
-
-	return deleteFilesFromArchive(archive, filesToDelete)
+	})
 }
 
-func deleteFilesFromArchive(mainArchive string, filesToDelete []*FileLocation) error {
-	mainTmpdir, err := createTempDir("ub_del_")
+// ============== Delete 逻辑 ==============
+func processDelete(archive string, config *Config) error {
+	format, err := lookupFormat(archive)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(mainTmpdir)
+	if err := requireCapability(format, CapCreate, "deleting files"); err != nil {
+		return err
+	}
 
-	if err := extractArchive(mainArchive, mainTmpdir); err != nil {
-		return fmt.Errorf("failed to extract main archive: %v", err)
+	filesToDelete, err := selectEntries(archive, "delete", config)
+	if err != nil {
+		return err
+	}
+	if len(filesToDelete) == 0 {
+		fmt.Println("No valid files to delete")
+		return nil
 	}
 
-	for _, loc := range filesToDelete {
-		if loc.IsNested {
-			nestedFileMainPath := filepath.Join(mainTmpdir, loc.NestedArchive)
-			nestedTmpdir, err := createTempDir("ub_nest_del_")
-			if err != nil { continue }
-
-			if extractArchive(nestedFileMainPath, nestedTmpdir) == nil {
-				fileToDelete := filepath.Join(nestedTmpdir, loc.ItemPath)
-				if err := os.RemoveAll(fileToDelete); err == nil {
-					fmt.Printf("Deleted nested file: %s\n", loc.ItemPath)
-					os.Remove(nestedFileMainPath)
-					compressArchive(nestedFileMainPath, nestedTmpdir)
-				}
-			}
-			os.RemoveAll(nestedTmpdir)
-		} else {
-			fileToDelete := filepath.Join(mainTmpdir, loc.ItemPath)
-			if err := os.RemoveAll(fileToDelete); err == nil {
-				fmt.Printf("Deleted file: %s\n", loc.ItemPath)
-			}
+	return deleteFilesFromArchive(archive, filesToDelete, &config.options)
+}
+
+func deleteFilesFromArchive(mainArchive string, filesToDelete []*FileLocation, opts *Options) error {
+	// 分卷名被占用时在改写归档之前就退出
+	if opts.VolumeSize > 0 {
+		if err := checkVolumesFree(mainArchive); err != nil {
+			return err
 		}
 	}
-
-	fmt.Printf("Recompressing main archive: %s\n", mainArchive)
-	if err := compressArchive(mainArchive, mainTmpdir); err != nil {
+	opts.resetBudget()
+	edits := newEditTree()
+	for _, loc := range filesToDelete {
+		node := edits.at(loc.Chain)
+		node.deletes = append(node.deletes, loc)
+	}
+	if err := edits.apply(mainArchive, false, opts); err != nil {
 		return err
 	}
+	if opts.VolumeSize > 0 {
+		if err := writeVolumes(mainArchive, opts.VolumeSize); err != nil {
+			return err
+		}
+	}
 	fmt.Println("Delete operation completed")
 	return nil
 }
 
 // ============== Extract 逻辑 ==============
 func processExtract(archive string, config *Config) error {
-	fmt.Println("Listing archive contents:")
-	if err := processList(archive, config); err != nil {
-		return err
-	}
-
-	if len(config.contentMap) == 0 {
-		fmt.Println("Archive is empty, no content to extract")
-		return nil
-	}
-
-	fmt.Print("Enter the number(s) to extract (space separated): ")
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
-	if err != nil {
-		return err
-	}
-
-	numbers := strings.Fields(input)
-	var filesToExtract []*FileLocation
-
-	for _, numStr := range numbers {
-		num, err := strconv.Atoi(numStr)
+	// 分卷只合并一次，选择条目与取出都读取合并后的归档
+	return withVolumes(archive, func(archive string) error {
+		filesToExtract, err := selectEntries(archive, "extract", config)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: '%s' is invalid, skipping\n", numStr)
-			continue
+			return err
 		}
-		if entry, exists := config.contentMap[num]; exists {
-			filesToExtract = append(filesToExtract, entry)
-		} else {
-			fmt.Fprintf(os.Stderr, "Warning: number '%d' does not exist\n", num)
+		if len(filesToExtract) == 0 {
+			fmt.Println("No valid files to extract")
+			return nil
 		}
-	}
-
-	if len(filesToExtract) == 0 {
-		fmt.Println("No valid files to extract")
-		return nil
-	}
 
-	return extractSelectedFiles(archive, filesToExtract)
+		return extractSelectedFiles(archive, filesToExtract, &config.options)
+	})
 }
 
-func extractSelectedFiles(mainArchive string, filesToExtract []*FileLocation) error {
-	mainTmpdir, err := createTempDir("ub_ext_")
+func extractSelectedFiles(mainArchive string, filesToExtract []*FileLocation, opts *Options) error {
+	opts.resetBudget()
+
+	// 选中的条目统一落到当前目录，同样要经过路径安全校验
+	root, err := newExtractRoot("", ".", opts)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(mainTmpdir)
-
-	if err := extractArchive(mainArchive, mainTmpdir); err != nil {
-		return fmt.Errorf("failed to extract main archive: %v", err)
-	}
+	var refused error
 
+	// 同一归档中的条目一起处理，每个嵌套归档只取出、解包一次
+	var chains [][]string
+	groups := make(map[string][]*FileLocation)
 	for _, loc := range filesToExtract {
-		var sourceFile string
-		if loc.IsNested {
-			nestedFileMainPath := filepath.Join(mainTmpdir, loc.NestedArchive)
-			nestedTmpdir, err := createTempDir("ub_nest_ext_")
-			if err != nil { continue }
-
-			if extractArchive(nestedFileMainPath, nestedTmpdir) == nil {
-				sourceFile = filepath.Join(nestedTmpdir, loc.ItemPath)
-				destFile := filepath.Join(".", filepath.Base(loc.ItemPath))
+		key := chainKey(loc.Chain)
+		if _, ok := groups[key]; !ok {
+			chains = append(chains, loc.Chain)
+		}
+		groups[key] = append(groups[key], loc)
+	}
 
+	for _, chain := range chains {
+		err := openChain(mainArchive, chain, opts, func(file string, format Format) error {
+			tmpdir, err := extractToTemp(file, "ub_ext_", opts)
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpdir)
+
+			for _, loc := range groups[chainKey(chain)] {
+				sourceFile := filepath.Join(tmpdir, filepath.FromSlash(loc.ItemPath))
+				// 嵌套归档中的文件直接放到当前目录，不保留目录结构
+				name := filepath.Clean(loc.ItemPath)
+				if loc.IsNested() {
+					name = filepath.Base(loc.ItemPath)
+				}
+				destFile, err := root.target(name)
+				if err == nil {
+					// 符号链接按链接本身复制，换了位置后它的指向也必须仍在当前目录内
+					if link, lerr := os.Readlink(sourceFile); lerr == nil {
+						err = root.checkSymlink(name, destFile, link)
+					}
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					refused = err
+					continue
+				}
 				if err := os.MkdirAll(filepath.Dir(destFile), 0755); err == nil {
 					if err := copyFile(sourceFile, destFile); err == nil {
-						fmt.Printf("Extracted: %s\n", destFile)
+						fmt.Printf("Extracted: %s\n", name)
 					}
 				}
 			}
-			os.RemoveAll(nestedTmpdir)
-		} else {
-			sourceFile = filepath.Join(mainTmpdir, loc.ItemPath)
-			destFile := filepath.Join(".", loc.ItemPath)
-
-			if err := os.MkdirAll(filepath.Dir(destFile), 0755); err == nil {
-				if err := copyFile(sourceFile, destFile); err == nil {
-					fmt.Printf("Extracted: %s\n", destFile)
-				}
-			}
+			return nil
+		})
+		if isLimitError(err) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", chainKey(append([]string{filepath.Base(mainArchive)}, chain...)), err)
 		}
 	}
 
+	if refused != nil {
+		return fmt.Errorf("some entries were refused")
+	}
 	fmt.Println("Extract operation completed")
 	return nil
 }
 
 // ============== 通用逻辑 ==============
-func addFilesToArchive(archive string, filesToAdd []string) error {
+func addFilesToArchive(archive string, filesToAdd []string, addOpts *AddOptions, opts *Options) error {
 	absArchive, err := filepath.Abs(archive)
 	if err != nil {
 		return fmt.Errorf("failed to resolve absolute path for archive: %v", err)
 	}
 
-	tmpdir, err := createTempDir("ub_add_")
+	format, err := lookupFormat(archive)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpdir)
-
-	if err := extractArchive(archive, tmpdir); err != nil {
-		return fmt.Errorf("extraction failed, cannot add files: %v", err)
+	if err := requireCapability(format, CapCreate, "adding files"); err != nil {
+		return err
 	}
 
-	// 新增：记录真实添加成功的文件数量
-	addedCount := 0
-
-	for _, file := range filesToAdd {
-		absFile, _ := filepath.Abs(file)
-		if absFile == absArchive {
-			fmt.Fprintf(os.Stderr, "Warning: cannot add archive '%s' to itself, skipping\n", file)
-			continue
-		}
-
-		if _, err := os.Stat(file); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: file '%s' does not exist, skipping\n", file)
-			continue
-		}
-		dst := filepath.Join(tmpdir, filepath.Base(file))
-		if err := copyFile(file, dst); err == nil {
-			fmt.Printf("Copied: %s\n", file)
-			addedCount++ // 新增：复制成功，计数器 +1
-		}
+	chain, into := splitInto(addOpts.Into)
+	adds, err := collectAdds(filesToAdd, absArchive, into, addOpts)
+	if err != nil {
+		return err
 	}
 
-	// 新增：拦截器。如果没有任何文件被成功复制，直接退出，不要重压缩
-	if addedCount == 0 {
+	// 拦截器：没有任何可添加的文件时直接退出，不要重压缩
+	if len(adds) == 0 {
 		fmt.Println("No new files were added. Archive remains unchanged.")
 		return nil
 	}
 
-	fmt.Printf("Recompressing to: %s\n", archive)
-	if err := compressArchive(absArchive, tmpdir); err != nil {
+	for _, add := range adds {
+		fmt.Printf("Adding: %s\n", &FileLocation{Chain: chain, ItemPath: add.Name})
+	}
+	if opts.VolumeSize > 0 {
+		if err := checkVolumesFree(absArchive); err != nil {
+			return err
+		}
+	}
+	// 与删除共用按嵌套链组织的修改：先改写最内层归档，再逐层写回外层
+	opts.resetBudget()
+	edits := newEditTree()
+	edits.at(chain).adds = adds
+	if err := edits.apply(absArchive, false, opts); err != nil {
 		return err
 	}
+	if opts.VolumeSize > 0 {
+		if err := writeVolumes(absArchive, opts.VolumeSize); err != nil {
+			return err
+		}
+	}
 
 	fmt.Println("Files added successfully")
 	return nil
 }
 
-func compressArchive(archive, sourceDir string) error {
+// compressArchive 用 sourceDir 的内容重建已有的归档，backup 为 true 时保留原文件为 .bak。
+// params 为 nil 时沿用原归档的参数
+func compressArchive(archive, sourceDir string, backup bool, params *CreateParams) error {
 	// 无论上层传入什么，强制转换为绝对路径，保证安全
 	absArchive, err := filepath.Abs(archive)
 	if err != nil {
 		return fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
-	if err := os.Remove(absArchive); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove original archive: %v", err)
+	// 已存在的归档按内容识别格式（如 .jar 按 zip 重建），新归档则按扩展名
+	format, err := lookupFormat(absArchive)
+	if err != nil {
+		return err
 	}
-
-	// 命令全部使用绝对路径 absArchive 进行输出
-	switch {
-	case strings.HasSuffix(archive, ".zip"):
-		return runCommandInDir(sourceDir, "zip", "-qr", absArchive, ".")
-	case strings.HasSuffix(archive, ".tar"):
-		return runCommand("tar", "cf", absArchive, "-C", sourceDir, ".")
-	case strings.HasSuffix(archive, ".tar.gz") || strings.HasSuffix(archive, ".tgz"):
-		return runCommand("tar", "czf", absArchive, "-C", sourceDir, ".")
-	case strings.HasSuffix(archive, ".tar.bz2") || strings.HasSuffix(archive, ".tbz2"):
-		return runCommand("tar", "cjf", absArchive, "-C", sourceDir, ".")
-	case strings.HasSuffix(archive, ".tar.xz") || strings.HasSuffix(archive, ".txz"):
-		return runCommand("tar", "cJf", absArchive, "-C", sourceDir, ".")
-	case strings.HasSuffix(archive, ".tar.zst") || strings.HasSuffix(archive, ".tzst"):
-		return runCommand("tar", "--zstd", "-cf", absArchive, "-C", sourceDir, ".")
-	case strings.HasSuffix(archive, ".7z"):
-		return runCommand("7z", "a", absArchive, sourceDir+"/.")
-	default:
-		return fmt.Errorf("unsupported format for adding files: %s", archive)
+	if err := requireCapability(format, CapCreate, "writing"); err != nil {
+		return err
 	}
+
+	return replaceArchive(format, absArchive, sourceDir, backup, params)
 }
 
 func stripArchiveExt(filename string) string {
 	base := filepath.Base(filename)
 	lower := strings.ToLower(base)
-	compoundExts := []string{".tar.bz2", ".tar.gz", ".tar.xz", ".tar.zst"}
+	compoundExts := []string{".tar.bz2", ".tar.gz", ".tar.xz", ".tar.zst", ".tar.lz4"}
 	for _, ext := range compoundExts {
 		if strings.HasSuffix(lower, ext) {
 			return base[:len(base)-len(ext)]
@@ -679,30 +1187,53 @@ func stripArchiveExt(filename string) string {
 	return strings.TrimSuffix(base, filepath.Ext(base))
 }
 
-func processFile(file string, config *Config) error {
-	if _, err := os.Stat(file); err != nil {
-		return fmt.Errorf("'%s' is not a valid file", file)
+func processFile(set *volumeSet, config *Config) error {
+	if err := set.check(); err != nil {
+		return err
+	}
+	for _, file := range set.parts {
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("'%s' is not a valid file", file)
+		}
+	}
+
+	// 旁挂的校验和与签名在创建目录、解压之前检查，分卷逐卷检查
+	if config.verify.Enabled {
+		for _, file := range set.parts {
+			if err := verifySidecars(file, &config.verify); err != nil {
+				return err
+			}
+		}
 	}
 
-	dest := stripArchiveExt(file)
+	dest := stripArchiveExt(set.name)
+	_, statErr := os.Stat(dest)
+	created := os.IsNotExist(statErr)
 	if err := os.MkdirAll(dest, 0755); err != nil {
 		return fmt.Errorf("failed to create directory '%s': %v", dest, err)
 	}
 
-	fmt.Printf("Extracting: %s -> %s/\n", file, dest)
-	if err := extractArchive(file, dest); err != nil {
+	fmt.Printf("Extracting: %s -> %s/\n", set, dest)
+	config.options.resetBudget()
+	if err := set.extract(dest, &config.options); err != nil {
+		// 超出配额时清理掉本次新建的目录，不留下半截的解压结果
+		if created && isLimitError(err) {
+			os.RemoveAll(dest)
+		}
 		return err
 	}
 
 	// Simple interactive deletion for full extraction mode
 	if config.deleteOrigin {
-		fmt.Printf("Delete original archive %s? (y/n): ", file)
+		fmt.Printf("Delete original archive %s? (y/n): ", set)
 		reader := bufio.NewReader(os.Stdin)
 		ans, _ := reader.ReadString('\n')
 		ans = strings.TrimSpace(strings.ToLower(ans))
 		if ans == "y" || ans == "yes" {
-			os.Remove(file)
-			fmt.Printf("Removed: %s\n", file)
+			for _, file := range set.parts {
+				os.Remove(file)
+				fmt.Printf("Removed: %s\n", file)
+			}
 		}
 	}
 
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ============== 条目摘要与清单 ==============
+
+// hashAlgorithms 是 --hash 支持的摘要算法
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256":  sha256.New,
+	"sha1":    sha1.New,
+	"md5":     md5.New,
+	"blake2b": newBlake2b,
+}
+
+// hashByLength 根据十六进制摘要的长度推断算法，用于校验未指明算法的清单
+func hashByLength(n int) (string, bool) {
+	for name, newHash := range hashAlgorithms {
+		if newHash().Size()*2 == n {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// entryHasher 计算归档中每个普通文件的摘要，嵌套归档本身与其中的条目都会计算
+type entryHasher struct {
+	newHash func() hash.Hash
+	opts    *Options
+}
+
+// hashArchive 依次对 archive 的条目调用 fn，name 为含嵌套链的完整位置，如 "inner.zip!/lib/a.so"
+func (h *entryHasher) hashArchive(archive string, format Format, chain []string, depth int, fn func(name, sum string)) error {
+	visit := func(e *Entry, r io.Reader) error {
+		// 硬链接与其目标内容相同，清单中只出现一次
+		if r == nil || !e.Mode.IsRegular() || e.Linkname != "" {
+			return nil
+		}
+		name := cleanEntryName(e.Name)
+		location := chainKey(appendChain(chain, name))
+		sum := h.newHash()
+		br := bufio.NewReaderSize(r, entryHeadSize)
+		head, _ := br.Peek(entryHeadSize)
+		if !sniffEntry(name, head) || !h.opts.Limits.allowsDepth(depth+1) {
+			if _, err := io.Copy(sum, br); err != nil {
+				return fmt.Errorf("%s: %w", location, err)
+			}
+			fn(location, hex.EncodeToString(sum.Sum(nil)))
+			return nil
+		}
+
+		// 嵌套归档一边计算摘要一边取出，取出的副本计入临时空间配额，然后继续计算其中的条目
+		tmpdir, nestedFile, err := readerToTemp(io.TeeReader(br, sum), name, "ub_hash_", h.opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", location, err)
+		}
+		defer os.RemoveAll(tmpdir)
+		fn(location, hex.EncodeToString(sum.Sum(nil)))
+		nested, err := lookupFormat(nestedFile)
+		if err != nil {
+			return nil
+		}
+		return h.hashArchive(nestedFile, nested, appendChain(chain, name), depth+1, fn)
+	}
+
+	if w, ok := format.(Walker); ok {
+		if err := w.Walk(archive, visit); err != errNotStreamable {
+			return err
+		}
+	}
+	tmpdir, err := extractToTemp(archive, "ub_hash_", h.opts)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", filepath.Base(archive), err)
+	}
+	defer os.RemoveAll(tmpdir)
+	return walkDirEntries(tmpdir, visit)
+}
+
+// hashFile 计算单个归档内全部条目的摘要
+func hashFile(archive, algorithm string, opts *Options, fn func(name, sum string)) error {
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("unknown hash algorithm '%s' (use sha256, sha1, md5 or blake2b)", algorithm)
+	}
+	opts.resetBudget()
+	h := &entryHasher{newHash: newHash, opts: opts}
+	// 分卷先合并为完整的归档再计算
+	return withVolumes(archive, func(archive string) error {
+		format, err := lookupFormat(archive)
+		if err != nil {
+			return err
+		}
+		return h.hashArchive(archive, format, nil, 0, fn)
+	})
+}
+
+// printManifest 以 sha256sum 的格式打印各归档的条目摘要；多个归档时条目名前加上 "归档!/"
+func printManifest(files []string, algorithm string, opts *Options) bool {
+	ok := true
+	for _, file := range files {
+		err := hashFile(file, algorithm, opts, func(name, sum string) {
+			if len(files) > 1 {
+				name = file + nestedSep + name
+			}
+			fmt.Println(formatChecksumLine(sum, name))
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error hashing %s: %v\n", file, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// formatChecksumLine 生成 "<摘要>  <名称>" 的一行；名称含换行或反斜杠时按 sha256sum 的规则转义
+func formatChecksumLine(sum, name string) string {
+	if strings.ContainsAny(name, "\n\\") {
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+		return "\\" + sum + "  " + name
+	}
+	return sum + "  " + name
+}
+
+// parseChecksumLine 解析一行校验和：GNU 格式 "<摘要>  <名称>"（二进制模式为 " *<名称>"）
+// 或 BSD 格式 "SHA256 (<名称>) = <摘要>"。空行与 "#" 开头的注释返回 ok == false
+func parseChecksumLine(line string) (sum, name string, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	if open := strings.Index(line, " ("); open > 0 && !strings.Contains(line[:open], " ") {
+		if close := strings.LastIndex(line, ") = "); close > open {
+			return strings.ToLower(line[close+4:]), line[open+2 : close], isHex(line[close+4:])
+		}
+	}
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+	sum, name, found := strings.Cut(line, " ")
+	if !found || !isHex(sum) || name == "" {
+		return "", "", false
+	}
+	if name[0] == ' ' || name[0] == '*' {
+		name = name[1:]
+	}
+	if escaped {
+		name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+	}
+	return strings.ToLower(sum), name, name != ""
+}
+
+func isHex(s string) bool {
+	if s == "" || len(s)%2 != 0 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// readManifest 读取清单文件，返回名称到摘要的映射
+func readManifest(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		sum, name, ok := parseChecksumLine(scanner.Text())
+		if !ok {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				return nil, fmt.Errorf("%s:%d: improperly formatted checksum line", file, n)
+			}
+			continue
+		}
+		sums[strings.TrimPrefix(name, "./")] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("no checksums found in %s", file)
+	}
+	return sums, nil
+}
+
+// verifyManifest 对照清单校验归档中的条目，打印每个条目的结果；有不匹配或缺失的条目时返回 false。
+// algorithm 为空时按摘要长度推断
+func verifyManifest(archive, manifest, algorithm string, opts *Options) (bool, error) {
+	expected, err := readManifest(manifest)
+	if err != nil {
+		return false, err
+	}
+	if algorithm == "" {
+		for _, sum := range expected {
+			var ok bool
+			if algorithm, ok = hashByLength(len(sum)); !ok {
+				return false, fmt.Errorf("cannot tell the hash algorithm of %s, use --hash", manifest)
+			}
+			break
+		}
+	}
+
+	failed, missing := 0, 0
+	seen := make(map[string]bool)
+	var extra []string
+	err = hashFile(archive, algorithm, opts, func(name, sum string) {
+		want, ok := expected[name]
+		if !ok {
+			extra = append(extra, name)
+			return
+		}
+		seen[name] = true
+		if sum == want {
+			fmt.Printf("%s: OK\n", name)
+		} else {
+			fmt.Printf("%s: \033[31mFAILED\033[0m\n", name)
+			failed++
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var names []string
+	for name := range expected {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: \033[31mMISSING\033[0m\n", name)
+		missing++
+	}
+	for _, name := range extra {
+		fmt.Fprintf(os.Stderr, "Warning: %s is not listed in the manifest\n", name)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s did NOT match\n", plural(failed, "computed checksum"))
+	}
+	if missing > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s missing from %s\n", plural(missing, "listed file"), archive)
+	}
+	return failed == 0 && missing == 0, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	tmp := t.TempDir()
+	inner := writeTestZip(t, [][2]string{{"c.txt", "c"}})
+	archive := filepath.Join(tmp, "a.zip")
+	files := [][2]string{{"a.txt", "a"}, {`dir/back\slash.txt`, "b"}, {"inner.zip", string(inner)}}
+	if err := os.WriteFile(archive, writeTestZip(t, files), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	sums := make(map[string]string)
+	err := hashFile(archive, "sha256", &Options{Limits: defaultLimits()}, func(name, sum string) {
+		sums[name] = sum
+		lines = append(lines, formatChecksumLine(sum, name))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256([]byte("c"))
+	if sums["inner.zip!/c.txt"] != hex.EncodeToString(want[:]) {
+		t.Fatalf("nested entry hashed as %q", sums["inner.zip!/c.txt"])
+	}
+	manifest := filepath.Join(tmp, "SHA256SUMS")
+	if err := os.WriteFile(manifest, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 算法由摘要长度推断，带反斜杠的名称经过转义后仍能对上
+	ok, err := verifyManifest(archive, manifest, "", &Options{Limits: defaultLimits()})
+	if err != nil || !ok {
+		t.Fatalf("verify = %v, %v", ok, err)
+	}
+
+	files[0][1] = "changed"
+	if err := os.WriteFile(archive, writeTestZip(t, files[:2]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ok, err = verifyManifest(archive, manifest, "", &Options{Limits: defaultLimits()})
+	if err != nil || ok {
+		t.Fatalf("changed and missing entries passed: %v, %v", ok, err)
+	}
+}
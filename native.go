@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ============== 原生后端共用的文件系统操作 ==============
+
+// unsafePathError 表示条目会写到解压目录之外（zip-slip / 路径穿越）
+type unsafePathError struct {
+	entry  string
+	reason string
+}
+
+func (e *unsafePathError) Error() string {
+	return fmt.Sprintf("refusing to extract '%s': %s (use --allow-unsafe-paths to override)", e.entry, e.reason)
+}
+
+// extractRoot 负责把条目安全地映射到解压目录中：
+// 拒绝绝对路径、".." 穿越、指向目录外的链接，以及借助已解压的符号链接写到目录外的条目
+type extractRoot struct {
+	dir     string // 已解析掉符号链接的解压目录绝对路径
+	opts    *Options
+	packed  int64 // 归档文件大小，用于计算压缩比
+	written int64 // 本归档已写出的字节数
+}
+
+// newExtractRoot 准备解压目录，archive 为空时不检查压缩比
+func newExtractRoot(archive, dest string, opts *Options) (*extractRoot, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	var packed int64
+	if archive != "" {
+		if fi, err := os.Stat(archive); err == nil {
+			packed = fi.Size()
+		}
+	}
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	return &extractRoot{dir: abs, opts: opts, packed: packed}, nil
+}
+
+// contains 判断 p 是否位于解压目录之内（含目录本身）
+func (r *extractRoot) contains(p string) bool {
+	rel, err := filepath.Rel(r.dir, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// target 返回条目在磁盘上的落点
+func (r *extractRoot) target(name string) (string, error) {
+	slashed := filepath.ToSlash(name)
+	if r.opts.AllowUnsafePaths {
+		if filepath.IsAbs(name) {
+			return filepath.Clean(name), nil
+		}
+		return filepath.Join(r.dir, filepath.FromSlash(slashed)), nil
+	}
+
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &unsafePathError{name, "absolute path"}
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "", &unsafePathError{name, "path contains '..'"}
+		}
+	}
+	target := filepath.Join(r.dir, filepath.FromSlash(path.Clean("/"+slashed)))
+	if target == r.dir {
+		// "./" 这类指向解压目录本身的条目
+		return target, nil
+	}
+
+	// 父目录中可能有前面条目刚写出的符号链接，解析后必须仍在解压目录内
+	parent := filepath.Dir(target)
+	for parent != r.dir && r.contains(parent) {
+		if _, err := os.Lstat(parent); err == nil {
+			break
+		}
+		parent = filepath.Dir(parent)
+	}
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", &unsafePathError{name, "parent directory is a dangling symlink"}
+	}
+	if !r.contains(resolved) {
+		return "", &unsafePathError{name, "parent directory is a symlink pointing outside the destination"}
+	}
+	return target, nil
+}
+
+// checkSymlink 校验符号链接的指向，target 为链接自身在磁盘上的落点
+func (r *extractRoot) checkSymlink(name, target, link string) error {
+	if r.opts.AllowUnsafePaths {
+		return nil
+	}
+	if !r.contains(r.resolveLink(filepath.Dir(target), link)) {
+		return &unsafePathError{name, "symlink points outside the destination: " + link}
+	}
+	return nil
+}
+
+// resolveLink 按内核的方式解析位于 dir 中、指向 link 的符号链接：逐段前进，
+// 遇到已解压出的符号链接先解析到真实位置，再处理其后的 ".."。
+// 只按字面拼接时，"s -> ." 之后的 "s/l -> ../x" 会被误判为仍在解压目录内
+func (r *extractRoot) resolveLink(dir, link string) string {
+	// 从已解析的解压目录出发，链接所在目录中尚未创建的部分也按同样的规则前进
+	cur := r.dir
+	var parts []string
+	if rel, err := filepath.Rel(r.dir, dir); err == nil && r.contains(dir) {
+		parts = strings.Split(filepath.ToSlash(rel), "/")
+	} else {
+		cur = dir
+	}
+	if filepath.IsAbs(link) {
+		cur, parts = string(filepath.Separator), nil
+	}
+	parts = append(parts, strings.Split(filepath.ToSlash(link), "/")...)
+	for _, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+			continue
+		}
+		cur = filepath.Join(cur, part)
+		if fi, err := os.Lstat(cur); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if resolved, err := filepath.EvalSymlinks(cur); err == nil {
+				cur = resolved
+			}
+		}
+	}
+	return cur
+}
+
+// checkEntries 在交给外部工具解压前预先校验全部条目，外部工具无法逐条拦截
+func (r *extractRoot) checkEntries(entries []Entry) error {
+	for _, e := range entries {
+		target, err := r.target(e.Name)
+		if err != nil {
+			return err
+		}
+		if e.Mode&os.ModeSymlink != 0 && e.Linkname != "" {
+			if err := r.checkSymlink(e.Name, target, e.Linkname); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile 将数据流写入目标文件，并恢复权限与修改时间；写入量计入配额
+func (root *extractRoot) writeFile(target string, r io.Reader, mode os.FileMode, mtime time.Time) error {
+	r = &limitedReader{r: r, root: root}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	// 目标可能是上一次解压留下的只读文件或符号链接，先移除再写入
+	os.Remove(target)
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if !mtime.IsZero() {
+		os.Chtimes(target, mtime, mtime)
+	}
+	return nil
+}
+
+func writeSymlink(target, link string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+	return os.Symlink(link, target)
+}
+
+// multiCloser 依次关闭多个资源，返回第一个错误
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// dirTimes 记录目录的修改时间，待目录内容写完后再统一恢复
+type dirTimes map[string]time.Time
+
+func (d dirTimes) restore() {
+	for dir, mtime := range d {
+		os.Chtimes(dir, mtime, mtime)
+	}
+}
+
+// dirModes 记录目录的权限，待目录内容写完后再统一设置，避免只读目录挡住其中条目的写入
+type dirModes map[string]os.FileMode
+
+func (d dirModes) restore() {
+	for dir, mode := range d {
+		os.Chmod(dir, mode)
+	}
+}
+
+// walkSourceDir 按字典序遍历待打包目录（不含根目录本身），name 为使用 "/" 分隔的相对路径。
+// 符号链接不会被跟随，交给回调按链接本身处理
+func walkSourceDir(root string, fn func(name, path string, fi os.FileInfo) error) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		return fn(filepath.ToSlash(rel), p, fi)
+	})
+}
+
+// walkPath 遍历要加入归档的 path（文件或目录本身，以及目录下的全部内容），name 为 path 在归档中的路径。
+// 回调对目录返回 filepath.SkipDir 时跳过该目录下的内容
+func walkPath(name, path string, fn func(name, path string, fi os.FileInfo) error) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(name, path, fi); err == filepath.SkipDir {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return nil
+	}
+	return walkSourceDir(path, func(rel, p string, fi os.FileInfo) error {
+		return fn(name+"/"+rel, p, fi)
+	})
+}
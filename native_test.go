@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTar 按顺序写出只含符号链接的 tar
+func writeTestTar(t *testing.T, file string, links [][2]string) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, l := range links {
+		hdr := &tar.Header{Name: l[0], Linkname: l[1], Typeflag: tar.TypeSymlink, Mode: 0777}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainedSymlinkCannotEscape(t *testing.T) {
+	cases := map[string][][2]string{
+		"existing parent": {{"s", "."}, {"s/l", "../outside_target"}},
+		"missing parent":  {{"s", "."}, {"s/new/l", "../../outside_target"}},
+		"link through":    {{"s", "."}, {"l", "s/../outside_target"}},
+	}
+	for name, links := range cases {
+		t.Run(name, func(t *testing.T) {
+			tmp := t.TempDir()
+			archive := filepath.Join(tmp, "evil.tar")
+			writeTestTar(t, archive, links)
+			dest := filepath.Join(tmp, "dest")
+
+			err := extractArchive(archive, dest, &Options{Limits: defaultLimits()})
+			var unsafe *unsafePathError
+			if !errors.As(err, &unsafe) {
+				t.Fatalf("expected an unsafe path error, got %v", err)
+			}
+			for _, l := range []string{"l", "new/l"} {
+				if _, err := os.Lstat(filepath.Join(dest, l)); err == nil {
+					t.Errorf("%s was created", l)
+				}
+			}
+		})
+	}
+}
+
+func TestSymlinkInsideDestination(t *testing.T) {
+	tmp := t.TempDir()
+	archive := filepath.Join(tmp, "ok.tar")
+	writeTestTar(t, archive, [][2]string{{"s", "."}, {"s/l", "s"}, {"d/up", "../s"}})
+	dest := filepath.Join(tmp, "dest")
+	if err := extractArchive(archive, dest, &Options{Limits: defaultLimits()}); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "l")); err != nil || link != "s" {
+		t.Fatalf("l = %q, %v", link, err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============== 任意深度的嵌套归档 ==============
+
+// archiveLevel 描述遍历中的一层归档
+type archiveLevel struct {
+	file   string // 磁盘上的归档文件，嵌套归档为临时文件
+	format Format
+	chain  []string // 从顶层归档到这一层依次经过的嵌套归档路径，顶层为空
+	ids    []string // 这一层之上各嵌套归档的内容摘要，用于发现循环嵌套
+}
+
+// child 返回嵌套在这一层中的归档 name 对应的下一层
+func (l *archiveLevel) child(name, file string, format Format, id string) *archiveLevel {
+	return &archiveLevel{
+		file:   file,
+		format: format,
+		chain:  appendChain(l.chain, name),
+		ids:    append(append([]string(nil), l.ids...), id),
+	}
+}
+
+// seen 判断内容摘要为 id 的归档是否已出现在上层，即归档直接或间接包含了自身
+func (l *archiveLevel) seen(id string) bool {
+	for _, s := range l.ids {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// appendChain 返回追加了一跳的新链，不与原链共用底层数组
+func appendChain(chain []string, name string) []string {
+	return append(append(make([]string, 0, len(chain)+1), chain...), name)
+}
+
+// chainKey 把嵌套链拼成 "a.zip!/b.tar.gz" 的形式
+func chainKey(chain []string) string {
+	return strings.Join(chain, nestedSep)
+}
+
+// fileDigest 返回文件内容的 SHA-256
+func fileDigest(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openChain 沿嵌套链逐跳把归档取出到临时文件，对最内层的归档调用 fn；fn 返回后临时文件全部删除
+func openChain(archive string, chain []string, opts *Options, fn func(file string, format Format) error) error {
+	if !opts.Limits.allowsDepth(len(chain)) {
+		return &limitError{"nesting depth", fmt.Sprint(opts.Limits.MaxDepth), "--max-depth"}
+	}
+	format, err := lookupFormat(archive)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 {
+		return fn(archive, format)
+	}
+	tmpdir, nestedFile, err := entryToTemp(format, archive, chain[0], "ub_nest_", opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", chain[0], err)
+	}
+	defer os.RemoveAll(tmpdir)
+	return openChain(nestedFile, chain[1:], opts, fn)
+}
+
+// editTree 按嵌套链组织对各层归档的修改，每个归档只解包、重新打包一次
+type editTree struct {
+	deletes  []*FileLocation
+	adds     []EditAdd // 加入本层归档的条目，在删除之后执行
+	children map[string]*editTree
+	order    []string // 子归档的加入顺序，保证输出稳定
+}
+
+func newEditTree() *editTree {
+	return &editTree{children: make(map[string]*editTree)}
+}
+
+// at 返回嵌套链对应的节点，不存在时创建
+func (t *editTree) at(chain []string) *editTree {
+	node := t
+	for _, hop := range chain {
+		child, ok := node.children[hop]
+		if !ok {
+			child = newEditTree()
+			node.children[hop] = child
+			node.order = append(node.order, hop)
+		}
+		node = child
+	}
+	return node
+}
+
+// apply 执行本层的修改并递归处理嵌套归档。支持直接编辑的格式逐条复制，其余格式解包后重新打包；
+// 顶层归档需要加密或原本就是加密的 zip 时也走重新打包，逐条复制无法加密
+func (t *editTree) apply(archive string, nested bool, opts *Options) error {
+	format, err := lookupFormat(archive)
+	if err != nil {
+		return err
+	}
+	var params *CreateParams
+	if !nested && opts.EncryptPassword != "" {
+		if err := requireCapability(format, CapEncrypt, "encrypting"); err != nil {
+			return err
+		}
+		params = &CreateParams{Password: opts.EncryptPassword}
+	}
+	// 逐条复制时新条目会以明文写入，含加密条目的 zip（包括嵌套的）改为解包后用原密码重新加密打包
+	if _, ok := format.(zipFormat); ok && params == nil && zipEncrypted(archive) {
+		if !commandExists("7z") {
+			return fmt.Errorf("7z command is required to rewrite encrypted zip archives, please install p7zip")
+		}
+		lock, err := unlock7z(archive)
+		if err != nil {
+			return err
+		}
+		params = &CreateParams{Password: lock.password}
+	}
+	if editor, ok := format.(Editor); ok && params == nil {
+		return t.applyInPlace(format, editor, archive, nested, opts)
+	}
+
+	tmpdir, err := extractToTemp(archive, "ub_edit_", opts)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", filepath.Base(archive), err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, loc := range t.deletes {
+		if err := os.RemoveAll(filepath.Join(tmpdir, filepath.FromSlash(loc.ItemPath))); err != nil {
+			return err
+		}
+		printDeleted(loc)
+	}
+	if err := stageAdds(tmpdir, t.adds); err != nil {
+		return err
+	}
+
+	for _, name := range t.order {
+		if err := t.children[name].apply(filepath.Join(tmpdir, filepath.FromSlash(name)), true, opts); err != nil {
+			return err
+		}
+	}
+
+	if nested {
+		fmt.Printf("Recompressing nested archive: %s\n", filepath.Base(archive))
+	} else {
+		fmt.Printf("Recompressing main archive: %s\n", archive)
+	}
+	return compressArchive(archive, tmpdir, !nested && opts.KeepBackup, params)
+}
+
+// applyInPlace 只取出需要改动的嵌套归档，其余条目由 Editor 从原归档原样复制
+func (t *editTree) applyInPlace(format Format, editor Editor, archive string, nested bool, opts *Options) error {
+	edit := &ArchiveEdit{}
+	for _, loc := range t.deletes {
+		edit.Deletes = append(edit.Deletes, loc.ItemPath)
+		printDeleted(loc)
+	}
+	edit.Adds = append(edit.Adds, t.adds...)
+
+	for _, name := range t.order {
+		tmpdir, nestedFile, err := entryToTemp(format, archive, name, "ub_edit_", opts)
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		defer os.RemoveAll(tmpdir)
+		if err := t.children[name].apply(nestedFile, true, opts); err != nil {
+			return err
+		}
+		edit.Adds = append(edit.Adds, EditAdd{Name: name, Path: nestedFile, KeepMeta: true})
+	}
+
+	if nested {
+		fmt.Printf("Rewriting nested archive: %s\n", filepath.Base(archive))
+	} else {
+		fmt.Printf("Rewriting main archive: %s\n", archive)
+	}
+	return editArchive(format, editor, archive, edit, !nested && opts.KeepBackup)
+}
+
+func printDeleted(loc *FileLocation) {
+	if loc.IsNested() {
+		fmt.Printf("Deleted nested file: %s\n", loc)
+	} else {
+		fmt.Printf("Deleted file: %s\n", loc)
+	}
+}
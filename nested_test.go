@@ -0,0 +1,56 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddToEncryptedZipStaysEncrypted(t *testing.T) {
+	if _, err := exec.LookPath("zip"); err != nil {
+		t.Skip("zip is not installed")
+	}
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(tmp, "enc.zip")
+	cmd := exec.Command("zip", "-q", "-P", "pw", "enc.zip", "secret.txt")
+	cmd.Dir = tmp
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("zip: %v\n%s", err, out)
+	}
+	before, _ := os.ReadFile(archive)
+	added := filepath.Join(tmp, "new.txt")
+	if err := os.WriteFile(added, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	passwords.candidates = []string{"pw"}
+	defer func() { passwords.candidates = nil }()
+	err := addFilesToArchive(archive, []string{added}, &AddOptions{}, &Options{Limits: defaultLimits()})
+	if !commandExists("7z") {
+		// 没有 7z 时不能重新加密，必须拒绝而不是写出明文条目
+		after, _ := os.ReadFile(archive)
+		if err == nil || !bytes.Equal(before, after) {
+			t.Fatalf("expected the edit to be refused, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Flags&0x1 == 0 {
+			t.Errorf("%s was written in plaintext", f.Name)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ============== 加密归档的密码 ==============
+
+// PasswordOptions 描述命令行给出的密码来源
+type PasswordOptions struct {
+	Password string // --password
+	File     string // --password-file：取文件的第一行，"-" 表示标准输入
+	List     string // --password-list：每行一个候选密码，依次尝试
+}
+
+// archiveLock 记录一个归档的加密情况以及确认可用的密码
+type archiveLock struct {
+	encrypted bool // 含加密条目
+	headers   bool // 文件名也被加密（7z -mhe / rar -hp），不带密码无法列出
+	password  string
+}
+
+// passwordSource 为加密归档提供密码。归档按路径只检查一次，嵌套归档与改写时生成的临时归档
+// 沿用同一组候选密码；没有任何候选时才在终端上询问
+type passwordSource struct {
+	mu         sync.Mutex
+	candidates []string
+	key        string // --password、--password-file 或 UNBOX_PASSWORD 明确给出的密码
+	prompt     bool
+	locks      map[string]*archiveLock
+}
+
+var passwords = &passwordSource{locks: make(map[string]*archiveLock)}
+
+// configure 按 --password、--password-file、UNBOX_PASSWORD 的顺序取第一个给出的密码，
+// 再追加 --password-list 中的候选
+func (s *passwordSource) configure(o *PasswordOptions) error {
+	if o.Password != "" && o.File != "" {
+		return fmt.Errorf("--password and --password-file cannot be used together")
+	}
+	switch {
+	case o.Password != "":
+		s.candidates = append(s.candidates, o.Password)
+	case o.File != "":
+		lines, err := readPasswordLines(o.File)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return fmt.Errorf("no password found in %s", o.File)
+		}
+		s.candidates = append(s.candidates, lines[0])
+	case os.Getenv("UNBOX_PASSWORD") != "":
+		s.candidates = append(s.candidates, os.Getenv("UNBOX_PASSWORD"))
+	}
+	if len(s.candidates) > 0 {
+		s.key = s.candidates[0]
+	}
+	if o.List != "" {
+		lines, err := readPasswordLines(o.List)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return fmt.Errorf("no passwords found in %s", o.List)
+		}
+		s.candidates = append(s.candidates, lines...)
+	}
+	s.prompt = len(s.candidates) == 0
+	return nil
+}
+
+// readPasswordLines 读取密码文件的非空行，行尾的 "\r" 一并去掉
+func readPasswordLines(file string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// unlock 返回 archive 的加密情况。probe 在不带密码的情况下检查归档，
+// try 用一个候选密码试解，只在有多个候选时使用
+func (s *passwordSource) unlock(archive string, probe func() archiveLock, try func(password string) bool) (*archiveLock, error) {
+	key, err := filepath.Abs(archive)
+	if err != nil {
+		key = archive
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.locks[key]; ok {
+		return l, nil
+	}
+	l := probe()
+	if l.encrypted {
+		if l.password, err = s.find(archive, try); err != nil {
+			return nil, err
+		}
+	}
+	s.locks[key] = &l
+	return &l, nil
+}
+
+func (s *passwordSource) find(archive string, try func(password string) bool) (string, error) {
+	switch {
+	case len(s.candidates) == 1:
+		return s.candidates[0], nil
+	case len(s.candidates) > 1:
+		for i, password := range s.candidates {
+			if try(password) {
+				// 嵌套归档多半与外层使用同一个密码，把它挪到最前面先试
+				copy(s.candidates[1:i+1], s.candidates[:i])
+				s.candidates[0] = password
+				return password, nil
+			}
+		}
+		return "", fmt.Errorf("none of the %d candidate passwords opens '%s'", len(s.candidates), filepath.Base(archive))
+	case s.prompt:
+		password, err := readPassword(fmt.Sprintf("Password for %s: ", filepath.Base(archive)))
+		if err != nil {
+			return "", fmt.Errorf("'%s' is encrypted: %v", filepath.Base(archive), err)
+		}
+		s.candidates = []string{password}
+		return password, nil
+	}
+	return "", fmt.Errorf("'%s' is encrypted, use --password, --password-file or UNBOX_PASSWORD", filepath.Base(archive))
+}
+
+// encryptionKey 返回加密新归档用的密码。没有明确给出时在终端上输入两次，
+// 输入的密码同时作为读取加密归档的第一个候选
+func (s *passwordSource) encryptionKey() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.key != "" {
+		return s.key, nil
+	}
+	password, err := readPassword("Encryption password: ")
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("the encryption password cannot be empty")
+	}
+	confirm, err := readPassword("Confirm password: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != password {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	s.key = password
+	s.candidates = append([]string{password}, s.candidates...)
+	s.prompt = false
+	return password, nil
+}
+
+// echoDisabled 记录终端回显是否被关闭，收到中断信号时据此恢复
+var echoDisabled atomic.Bool
+
+// readPassword 在控制终端上不回显地读取一行密码，标准输入被重定向时同样可用
+func readPassword(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("no terminal to ask for the password")
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	if setEcho(tty, false) == nil {
+		echoDisabled.Store(true)
+		defer func() {
+			setEcho(tty, true)
+			echoDisabled.Store(false)
+			fmt.Fprintln(tty)
+		}()
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// setEcho 通过 stty 开关终端回显
+func setEcho(tty *os.File, on bool) error {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
+
+// restoreTerminal 在密码输入途中被中断时恢复回显
+func restoreTerminal() {
+	if !echoDisabled.Load() {
+		return
+	}
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		setEcho(tty, true)
+		fmt.Fprintln(tty)
+		tty.Close()
+	}
+}
+
+// ============== 把密码交给外部工具 ==============
+
+// 命令行参数对同一台机器上的其他用户可见（ps、/proc/<pid>/cmdline），所以密码不用 -p<密码> 传给
+// 7z 与 unrar：读取时不给出 -p，创建时只给出不带值的 -p，由工具询问密码，再从标准输入答复
+
+// passwordCommand 构造由标准输入提供密码的命令，子进程与终端的分离见 detachTerminal
+func passwordCommand(password, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	// 创建加密归档时 7z 会要求再输入一次确认，只询问一次时多出的一行不会被读取
+	cmd.Stdin = strings.NewReader(password + "\n" + password + "\n")
+	detachTerminal(cmd)
+	return cmd
+}
+
+// command7z 构造读取归档的 7z 命令，sub 为子命令。未加密的归档给出空密码 -p，
+// 防止 7z 在终端上询问；加密的归档不带 -p，由 7z 询问后从标准输入读到密码
+func (l *archiveLock) command7z(sub string, args ...string) *exec.Cmd {
+	if !l.encrypted {
+		return exec.Command("7z", append([]string{sub, "-p"}, args...)...)
+	}
+	return passwordCommand(l.password, "7z", append([]string{sub}, args...)...)
+}
+
+// commandRar 与 command7z 相同，供 unrar 使用；-p- 让 unrar 不询问密码
+func (l *archiveLock) commandRar(sub string, args ...string) *exec.Cmd {
+	if !l.encrypted {
+		return exec.Command("unrar", append([]string{sub, "-p-"}, args...)...)
+	}
+	return passwordCommand(l.password, "unrar", append([]string{sub}, args...)...)
+}
+
+// promptFilter 把外部工具的错误输出按行转给 w，去掉询问密码的提示。
+// 没有终端时 getpass 不会在提示后换行，提示之后的内容仍然保留
+type promptFilter struct {
+	w    io.Writer
+	line []byte
+}
+
+func hidePrompts(w io.Writer) *promptFilter {
+	return &promptFilter{w: w}
+}
+
+func (f *promptFilter) Write(b []byte) (int, error) {
+	f.line = append(f.line, b...)
+	for {
+		i := strings.IndexByte(string(f.line), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		f.emit(string(f.line[:i+1]))
+		f.line = f.line[i+1:]
+	}
+}
+
+// Close 在工具退出后输出最后一行没有换行的内容
+func (f *promptFilter) Close() error {
+	f.emit(string(f.line))
+	f.line = nil
+	return nil
+}
+
+// emit 去掉行首的 "Enter password ...:" 与 "Verify password ...:" 提示后输出
+func (f *promptFilter) emit(line string) {
+	prompted := false
+	for {
+		rest := strings.TrimLeft(line, " \r\n")
+		if !strings.HasPrefix(rest, "Enter password") && !strings.HasPrefix(rest, "Verify password") {
+			break
+		}
+		i := strings.IndexByte(rest, ':')
+		if i < 0 {
+			return
+		}
+		line = strings.TrimPrefix(rest[i+1:], " ")
+		prompted = true
+	}
+	if !prompted || strings.TrimSpace(line) != "" {
+		f.w.Write([]byte(line))
+	}
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachTerminal 把子进程放到没有控制终端的新会话中，工具（getpass）询问密码时因此读取标准输入；
+// unbox 退出时子进程随之结束
+func detachTerminal(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Pdeathsig: syscall.SIGKILL}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// detachTerminal 在其他平台上不做处理，工具仍可能直接在终端上询问密码
+func detachTerminal(cmd *exec.Cmd) {}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasswordCandidates(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "pw")
+	list := filepath.Join(tmp, "list")
+	if err := os.WriteFile(file, []byte("first\r\nignored\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(list, []byte("a\n\nb\nc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &passwordSource{locks: make(map[string]*archiveLock)}
+	if err := s.configure(&PasswordOptions{File: file, List: list}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "a", "b", "c"}; !reflect.DeepEqual(s.candidates, want) {
+		t.Fatalf("candidates = %v, want %v", s.candidates, want)
+	}
+	if s.key != "first" || s.prompt {
+		t.Fatalf("key = %q, prompt = %v", s.key, s.prompt)
+	}
+
+	// 试出的密码挪到最前面，嵌套归档先试它
+	password, err := s.find("x.7z", func(p string) bool { return p == "b" })
+	if err != nil || password != "b" {
+		t.Fatalf("find = %q, %v", password, err)
+	}
+	if want := []string{"b", "first", "a", "c"}; !reflect.DeepEqual(s.candidates, want) {
+		t.Fatalf("candidates after find = %v, want %v", s.candidates, want)
+	}
+	if _, err := s.find("x.7z", func(string) bool { return false }); err == nil {
+		t.Fatal("expected an error when no candidate opens the archive")
+	}
+
+	if err := (&passwordSource{}).configure(&PasswordOptions{Password: "p", File: file}); err == nil {
+		t.Fatal("expected --password with --password-file to be rejected")
+	}
+}
+
+func TestPasswordCommandUsesStdin(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	// 模拟 7z 创建加密归档时的两次询问
+	cmd := passwordCommand("s3cret", "sh", "-c", `read p; read v; printf '%s %s' "$p" "$v"`)
+	for _, arg := range cmd.Args {
+		if strings.Contains(arg, "s3cret") {
+			t.Fatalf("password leaked into the command line: %v", cmd.Args)
+		}
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "s3cret s3cret" {
+		t.Fatalf("tool read %q", out)
+	}
+
+	lock := &archiveLock{}
+	if args := lock.command7z("x", "a.7z").Args; !reflect.DeepEqual(args, []string{"7z", "x", "-p", "a.7z"}) {
+		t.Fatalf("unencrypted 7z args = %v", args)
+	}
+	lock = &archiveLock{encrypted: true, password: "s3cret"}
+	if args := lock.command7z("x", "a.7z").Args; !reflect.DeepEqual(args, []string{"7z", "x", "a.7z"}) {
+		t.Fatalf("encrypted 7z args = %v", args)
+	}
+}
+
+func TestPromptFilter(t *testing.T) {
+	var buf bytes.Buffer
+	f := hidePrompts(&buf)
+	f.Write([]byte("Enter password (will not be echoed):"))
+	f.Write([]byte("\nVerify password (will not be echoed):ERROR: Wrong password\n"))
+	f.Write([]byte("Everything is Ok"))
+	f.Close()
+	if want := "ERROR: Wrong password\nEverything is Ok"; buf.String() != want {
+		t.Fatalf("filtered output = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ============== 原子化重写归档 ==============
+
+// backupSuffix 是重写前保留的原归档副本的后缀
+const backupSuffix = ".bak"
+
+// pendingFiles 记录尚未完成的临时归档，收到中断信号时清理
+var pendingFiles sync.Map
+
+func removePendingFiles() {
+	pendingFiles.Range(func(key, _ interface{}) bool {
+		os.Remove(key.(string))
+		return true
+	})
+}
+
+// replaceArchive 把 sourceDir 打包为 archive，原归档存在时尽量按其参数重建（见 Repacker）；
+// 给出 params 时改用 Builder 按 params 创建
+func replaceArchive(format Format, archive, sourceDir string, backup bool, params *CreateParams) error {
+	return commitArchive(archive, backup, func(tmpName string) error {
+		if params != nil {
+			builder, ok := format.(Builder)
+			if !ok {
+				return fmt.Errorf("creating %s archives is not supported", format.Name())
+			}
+			adds, err := sourceDirAdds(sourceDir)
+			if err != nil {
+				return err
+			}
+			return builder.Build(tmpName, adds, params)
+		}
+		if r, ok := format.(Repacker); ok {
+			if _, err := os.Stat(archive); err == nil {
+				return r.Repack(archive, tmpName, sourceDir)
+			}
+		}
+		return format.Create(tmpName, sourceDir)
+	}, func(tmpName string) error {
+		return verifyArchive(format, tmpName, sourceDir)
+	})
+}
+
+// sourceDirAdds 把 dir 下的全部内容转换为 Builder 使用的条目列表
+func sourceDirAdds(dir string) ([]EditAdd, error) {
+	var adds []EditAdd
+	err := walkSourceDir(dir, func(name, path string, fi os.FileInfo) error {
+		adds = append(adds, EditAdd{Name: name, Path: path})
+		return nil
+	})
+	return adds, err
+}
+
+// editArchive 按 edit 直接编辑 archive，未改动的条目从原归档原样复制
+func editArchive(format Format, editor Editor, archive string, edit *ArchiveEdit, backup bool) error {
+	return commitArchive(archive, backup, func(tmpName string) error {
+		return editor.Edit(archive, tmpName, edit)
+	}, func(tmpName string) error {
+		return verifyEdit(format, tmpName, edit)
+	})
+}
+
+// commitArchive 先由 write 写出同目录下的临时文件，经 verify 校验并落盘后再重命名覆盖 archive。
+// 任何一步失败原归档都保持不变；backup 为 true 时原归档保留为 archive.bak
+func commitArchive(archive string, backup bool, write, verify func(tmpName string) error) error {
+	archive, err := filepath.Abs(archive)
+	if err != nil {
+		return err
+	}
+	dir, base := filepath.Split(archive)
+	// 临时文件保留原文件名作为后缀，按扩展名决定格式的外部工具才能正确处理
+	tmp, err := os.CreateTemp(dir, ".unbox-*-"+base)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary archive: %v", err)
+	}
+	tmpName := tmp.Name()
+	tmp.Close()
+	// 7z 等工具会向已存在的文件追加，先删掉占位文件，只保留这个名字
+	os.Remove(tmpName)
+	pendingFiles.Store(tmpName, true)
+	defer func() {
+		os.Remove(tmpName)
+		pendingFiles.Delete(tmpName)
+	}()
+
+	if err := write(tmpName); err != nil {
+		return fmt.Errorf("failed to write %s: %w", base, err)
+	}
+	if err := verify(tmpName); err != nil {
+		return fmt.Errorf("new %s failed verification, original kept: %v", base, err)
+	}
+
+	if fi, err := os.Stat(archive); err == nil {
+		os.Chmod(tmpName, fi.Mode().Perm())
+	}
+	if err := syncFile(tmpName); err != nil {
+		return err
+	}
+
+	if backup {
+		if err := backupArchive(archive); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmpName, archive); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", base, err)
+	}
+	return syncFile(dir)
+}
+
+// verifyArchive 重新读取刚写出的归档，确认其中的文件数与打包前一致
+func verifyArchive(format Format, archive, sourceDir string) error {
+	expected := 0
+	err := walkSourceDir(sourceDir, func(name, path string, fi os.FileInfo) error {
+		if fi.Mode().IsRegular() || fi.Mode()&os.ModeSymlink != 0 {
+			expected++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return verifyFileCount(format, archive, expected)
+}
+
+// verifyFileCount 重新读取刚写出的归档，确认其中目录以外的条目恰好有 expected 个
+func verifyFileCount(format Format, archive string, expected int) error {
+	entries, err := format.List(archive)
+	if err != nil {
+		return err
+	}
+	written := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			written++
+		}
+	}
+	if written != expected {
+		return fmt.Errorf("expected %d files, found %d", expected, written)
+	}
+	return nil
+}
+
+// verifyEdit 重新读取编辑后的归档，确认新增的条目都在、删除的条目都已不在
+func verifyEdit(format Format, archive string, edit *ArchiveEdit) error {
+	entries, err := format.List(archive)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		present[cleanEntryName(e.Name)] = true
+	}
+	for _, add := range edit.Adds {
+		if !present[add.Name] {
+			return fmt.Errorf("'%s' is missing", add.Name)
+		}
+	}
+	for name := range present {
+		if edit.removes(name) && !edit.replaces(name) {
+			return fmt.Errorf("'%s' was not removed", name)
+		}
+	}
+	return nil
+}
+
+// syncFile 把文件或目录的内容刷到磁盘
+func syncFile(path string) error {
+	if path == "" {
+		path = "."
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil && !isDirSyncUnsupported(path) {
+		return fmt.Errorf("failed to sync %s: %v", path, err)
+	}
+	return nil
+}
+
+// isDirSyncUnsupported 部分平台与文件系统不支持对目录 fsync，这种情况忽略即可
+func isDirSyncUnsupported(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// backupArchive 把当前的归档保留为 archive.bak，优先使用硬链接避免复制
+func backupArchive(archive string) error {
+	bak := archive + backupSuffix
+	os.Remove(bak)
+	if err := os.Link(archive, bak); err == nil {
+		return nil
+	}
+	if err := copyFile(archive, bak); err != nil {
+		os.Remove(bak)
+		return fmt.Errorf("failed to back up %s: %v", filepath.Base(archive), err)
+	}
+	return nil
+}
+
+// restoreBackup 用 archive.bak 覆盖 archive，撤销最近一次修改
+func restoreBackup(archive string) error {
+	bak := archive + backupSuffix
+	if _, err := os.Stat(bak); err != nil {
+		return fmt.Errorf("no backup found for '%s' (expected %s)", archive, bak)
+	}
+	if err := os.Rename(bak, archive); err != nil {
+		return fmt.Errorf("failed to restore '%s': %v", archive, err)
+	}
+	return syncFile(filepath.Dir(archive))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============== -e / -d 的条目选择 ==============
+
+// nestedSep 分隔嵌套归档与其中的路径，如 "inner.zip!/docs/a.md"
+const nestedSep = "!/"
+
+// String 返回条目在选择器中使用的路径
+func (l *FileLocation) String() string {
+	return chainKey(appendChain(l.Chain, l.ItemPath))
+}
+
+// selectEntries 决定 -e / -d 要处理的条目：
+// 命令行或 --select-from 给出了选择器时直接解析，否则打印树形列表并交互式询问编号
+func selectEntries(archive, action string, config *Config) ([]*FileLocation, error) {
+	selectors := config.selectors
+	if config.selectFrom != "" {
+		more, err := readSelectors(config.selectFrom)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, more...)
+	}
+
+	if len(selectors) > 0 || config.selectFrom != "" {
+		config.quiet = true
+		err := processList(archive, config)
+		config.quiet = false
+		if err != nil {
+			return nil, err
+		}
+		return resolveSelectors(config.contentMap, selectors, true)
+	}
+
+	fmt.Println("Listing archive contents:")
+	if err := processList(archive, config); err != nil {
+		return nil, err
+	}
+	if len(config.contentMap) == 0 {
+		fmt.Printf("Archive is empty, no content to %s\n", action)
+		return nil, nil
+	}
+
+	fmt.Printf("Enter the number(s) to %s (space separated): ", action)
+	reader := bufio.NewReader(os.Stdin)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return resolveSelectors(config.contentMap, strings.Fields(input), false)
+}
+
+// readSelectors 从文件读取选择器，每行一个，忽略空行与 "#" 开头的注释；"-" 表示标准输入
+func readSelectors(file string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var selectors []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		selectors = append(selectors, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	return selectors, nil
+}
+
+// resolveSelectors 把选择器解析为条目。选择器可以是：
+//   - 编号 "3" 或编号区间 "7-12"
+//   - 路径或 glob，如 "docs/*.md"；匹配到目录时选中其下全部条目
+//   - 嵌套归档中的路径，如 "inner.zip!/lib/*.so"
+//
+// strict 为 true 时任何无法匹配的选择器都是错误，否则只给出警告（交互模式）
+func resolveSelectors(contentMap map[int]*FileLocation, selectors []string, strict bool) ([]*FileLocation, error) {
+	numbers := make([]int, 0, len(contentMap))
+	for n := range contentMap {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	chosen := make(map[int]bool)
+	for _, sel := range selectors {
+		matched, err := matchSelector(contentMap, numbers, sel)
+		if err == nil && len(matched) == 0 {
+			err = fmt.Errorf("no entries match '%s'", sel)
+		}
+		if err != nil {
+			if strict {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %v, skipping\n", err)
+			continue
+		}
+		for _, n := range matched {
+			chosen[n] = true
+		}
+	}
+
+	var locs []*FileLocation
+	for _, n := range numbers {
+		if chosen[n] {
+			locs = append(locs, contentMap[n])
+		}
+	}
+	return locs, nil
+}
+
+func matchSelector(contentMap map[int]*FileLocation, numbers []int, sel string) ([]int, error) {
+	if lo, hi, ok := parseNumberRange(sel); ok {
+		var matched []int
+		for n := lo; n <= hi; n++ {
+			if _, exists := contentMap[n]; !exists {
+				return nil, fmt.Errorf("number '%d' does not exist", n)
+			}
+			matched = append(matched, n)
+		}
+		return matched, nil
+	}
+
+	pattern := strings.TrimSuffix(strings.TrimPrefix(sel, "./"), "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s'", sel)
+	}
+	var matched []int
+	for _, n := range numbers {
+		if selectorMatches(pattern, contentMap[n].String()) {
+			matched = append(matched, n)
+		}
+	}
+	return matched, nil
+}
+
+// selectorMatches 判断 pattern 是否匹配 name 本身或其任意一级父目录
+func selectorMatches(pattern, name string) bool {
+	for p := name; ; {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			return false
+		}
+		// 不跨过嵌套归档的边界，"inner.zip" 只选中归档文件本身
+		if p = p[:i]; strings.HasSuffix(p, "!") {
+			return false
+		}
+	}
+}
+
+// parseNumberRange 解析 "3" 或 "7-12"
+func parseNumberRange(s string) (int, int, bool) {
+	loStr, hiStr, isRange := strings.Cut(s, "-")
+	lo, err := strconv.Atoi(loStr)
+	if err != nil || lo <= 0 {
+		return 0, 0, false
+	}
+	if !isRange {
+		return lo, lo, true
+	}
+	hi, err := strconv.Atoi(hiStr)
+	if err != nil || hi < lo {
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeTestZip 把 files 按给出的顺序写入 zip，返回其内容
+func writeTestZip(t *testing.T, files [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f[1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// selectedNames 用选择器选中 archive 中的条目，返回它们的路径
+func selectedNames(t *testing.T, archive string, selectors ...string) []string {
+	t.Helper()
+	config := &Config{selectors: selectors, options: Options{Limits: defaultLimits()}}
+	locs, err := selectEntries(archive, "extract", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, loc := range locs {
+		names = append(names, loc.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSelectorsRoundTrip(t *testing.T) {
+	tmp := t.TempDir()
+	inner := writeTestZip(t, [][2]string{{"lib/x.so", "x"}, {"lib/y.txt", "y"}})
+	archive := filepath.Join(tmp, "outer.zip")
+	outer := writeTestZip(t, [][2]string{
+		{"docs/a.md", "a"}, {"docs/b.txt", "b"}, {"top.txt", "top"}, {"inner.zip", string(inner)},
+	})
+	if err := os.WriteFile(archive, outer, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := selectedNames(t, archive, "docs/*.md", "inner.zip!/lib/*.so")
+	if want := []string{"docs/a.md", "inner.zip!/lib/x.so"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("selected %v, want %v", got, want)
+	}
+	// 无法匹配的选择器在命令行模式下是错误
+	config := &Config{selectors: []string{"missing/*"}, options: Options{Limits: defaultLimits()}}
+	if _, err := selectEntries(archive, "extract", config); err == nil {
+		t.Fatal("expected an unmatched selector to fail")
+	}
+
+	// 取出选中的条目：主归档中的保留目录结构，嵌套归档中的直接放到当前目录
+	dest := filepath.Join(tmp, "out")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dest); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	config = &Config{selectors: []string{"docs/*.md", "inner.zip!/lib/*.so"}, options: Options{Limits: defaultLimits()}}
+	if err := processExtract(archive, config); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"docs/a.md": "a", "x.so": "x"} {
+		if data, err := os.ReadFile(filepath.Join(dest, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "docs", "b.txt")); err == nil {
+		t.Error("docs/b.txt was extracted without being selected")
+	}
+
+	// 选中目录时删除其下全部条目，嵌套归档中的条目同样可以删除
+	config = &Config{selectors: []string{"docs", "inner.zip!/lib/y.txt"}, options: Options{Limits: defaultLimits()}}
+	if err := processDelete(archive, config); err != nil {
+		t.Fatal(err)
+	}
+	got = selectedNames(t, archive, "*", "inner.zip!/*")
+	if want := []string{"inner.zip", "inner.zip!/lib/x.so", "top.txt"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after delete %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============== 校验和与签名旁挂文件 ==============
+
+// VerifyOptions 控制解压前对旁挂校验文件的检查
+type VerifyOptions struct {
+	Enabled     bool   // 解压前查找 .sha256 / SHA256SUMS / .minisig 等旁挂文件
+	Require     bool   // 没有任何校验或签名通过时拒绝解压
+	TrustedKeys string // 受信任的公钥文件，为空时使用 defaultTrustedKeys()
+}
+
+// checksumSidecars 是与归档同名的校验和文件后缀及对应算法
+var checksumSidecars = []struct{ suffix, algorithm string }{
+	{".sha256", "sha256"},
+	{".sha256sum", "sha256"},
+	{".sha1", "sha1"},
+	{".md5", "md5"},
+	{".b2", "blake2b"},
+}
+
+// sumsFiles 是同目录下汇总多个文件校验和的清单文件
+var sumsFiles = []struct{ name, algorithm string }{
+	{"SHA256SUMS", "sha256"},
+	{"SHA1SUMS", "sha1"},
+	{"MD5SUMS", "md5"},
+	{"B2SUMS", "blake2b"},
+}
+
+// maxUnhashedSigned 是不经预哈希直接签名（原始 ed25519 与 minisign 旧式 "Ed"）的归档大小上限。
+// 这类签名无法流式校验，必须把整个归档读入内存；更大的归档应使用 minisign 的预哈希签名（"ED"）
+const maxUnhashedSigned = 256 << 20
+
+// signatureSuffixes 是签名旁挂文件的后缀：minisign 格式，或原始 / base64 编码的 ed25519 签名
+var signatureSuffixes = []string{".minisig", ".sig"}
+
+// defaultTrustedKeys 返回默认的受信任公钥文件位置
+func defaultTrustedKeys() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "unbox", "trusted-keys")
+}
+
+// verifySidecars 在解压 archive 之前检查旁挂的校验和与签名。任何一项不匹配都拒绝解压；
+// 找不到旁挂文件时只给出警告，除非指定了 --require-verified
+func verifySidecars(archive string, vo *VerifyOptions) error {
+	verified := false
+	found := false
+	sums := make(map[string]string) // 同一算法只计算一次
+
+	for _, c := range findChecksums(archive) {
+		found = true
+		sum, ok := sums[c.algorithm]
+		if !ok {
+			var err error
+			if sum, err = fileChecksum(archive, c.algorithm); err != nil {
+				return err
+			}
+			sums[c.algorithm] = sum
+		}
+		if sum != c.sum {
+			return fmt.Errorf("%s checksum of '%s' does not match %s, refusing to extract", c.algorithm, archive, c.source)
+		}
+		fmt.Printf("Verified %s checksum against %s\n", c.algorithm, c.source)
+		verified = true
+	}
+
+	var keys []trustedKey
+	keysLoaded := false
+	for _, suffix := range signatureSuffixes {
+		sigFile := archive + suffix
+		data, err := os.ReadFile(sigFile)
+		if err != nil {
+			continue
+		}
+		// .sig 也常用于 GPG 签名，这类文件无法校验，只提示
+		if !isEd25519Signature(data) {
+			fmt.Fprintf(os.Stderr, "Warning: %s is not an ed25519 or minisign signature, ignored\n", filepath.Base(sigFile))
+			continue
+		}
+		found = true
+		if !keysLoaded {
+			var err error
+			if keys, err = loadTrustedKeys(vo.TrustedKeys); err != nil {
+				return err
+			}
+			keysLoaded = true
+		}
+		if len(keys) == 0 {
+			if vo.Require {
+				return fmt.Errorf("'%s' is signed but no trusted keys are configured (use --trusted-keys)", archive)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %s found but no trusted keys are configured, signature not checked\n", filepath.Base(sigFile))
+			continue
+		}
+		signer, err := verifySignature(archive, data, keys)
+		if err != nil {
+			return fmt.Errorf("signature check of '%s' failed: %v, refusing to extract", archive, err)
+		}
+		fmt.Printf("Verified signature %s by key %s\n", filepath.Base(sigFile), signer)
+		verified = true
+	}
+
+	if !verified {
+		if vo.Require {
+			return fmt.Errorf("no valid checksum or signature found for '%s' (--require-verified)", archive)
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Warning: no checksum or signature found for %s\n", archive)
+		}
+	}
+	return nil
+}
+
+// expectedChecksum 是从旁挂文件中读到的一个期望值
+type expectedChecksum struct {
+	algorithm string
+	sum       string
+	source    string // 读到该值的文件，用于提示
+}
+
+// findChecksums 收集 archive 的全部旁挂校验和：同名的 .sha256 等文件，以及同目录 SHA256SUMS 等清单中的对应行
+func findChecksums(archive string) []expectedChecksum {
+	var found []expectedChecksum
+	base := filepath.Base(archive)
+	for _, s := range checksumSidecars {
+		if sum, ok := lookupChecksum(archive+s.suffix, base, true); ok {
+			found = append(found, expectedChecksum{s.algorithm, sum, filepath.Base(archive + s.suffix)})
+		}
+	}
+	for _, s := range sumsFiles {
+		sumsFile := filepath.Join(filepath.Dir(archive), s.name)
+		if sum, ok := lookupChecksum(sumsFile, base, false); ok {
+			found = append(found, expectedChecksum{s.algorithm, sum, s.name})
+		}
+	}
+	return found
+}
+
+// lookupChecksum 在校验和文件中查找 name 对应的摘要。single 为 true 时文件只针对一个归档，
+// 可以只有一个摘要而没有文件名，名称不一致的唯一一行也视为该归档的摘要
+func lookupChecksum(file, name string, single bool) (string, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", false
+	}
+	var only []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if single && isHex(line) {
+			return strings.ToLower(line), true
+		}
+		sum, entry, ok := parseChecksumLine(line)
+		if !ok {
+			continue
+		}
+		if entry == name || filepath.Base(strings.TrimPrefix(entry, "./")) == name {
+			return sum, true
+		}
+		only = append(only, sum)
+	}
+	if single && len(only) == 1 {
+		return only[0], true
+	}
+	return "", false
+}
+
+// fileChecksum 计算文件的十六进制摘要
+func fileChecksum(file, algorithm string) (string, error) {
+	sum, err := sumFile(file, hashAlgorithms[algorithm]())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// sumFile 流式计算文件的摘要
+func sumFile(file string, h hash.Hash) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// readUnhashedSigned 读入整个归档作为签名对象，超过 maxUnhashedSigned 时拒绝
+func readUnhashedSigned(archive string) ([]byte, error) {
+	fi, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxUnhashedSigned {
+		return nil, fmt.Errorf("archive is larger than %d MB, too large for a signature without prehashing (sign it with minisign -H)", maxUnhashedSigned>>20)
+	}
+	return os.ReadFile(archive)
+}
+
+// trustedKey 是一个受信任的 ed25519 公钥，minisign 公钥带有 8 字节的 key id
+type trustedKey struct {
+	id  []byte
+	key ed25519.PublicKey
+}
+
+// name 返回公钥在提示中的名称：minisign key id 按 minisign 的写法显示，其余显示公钥开头
+func (k trustedKey) name() string {
+	if k.id != nil {
+		return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.id))
+	}
+	return hex.EncodeToString(k.key[:8])
+}
+
+// loadTrustedKeys 读取受信任的公钥文件，每行一个公钥：minisign 公钥（base64，可带 "untrusted comment:" 行），
+// 或 32 字节 ed25519 公钥的 base64 / 十六进制。空行与 "#" 注释跳过。未指定且默认文件不存在时返回空
+func loadTrustedKeys(file string) ([]trustedKey, error) {
+	explicit := file != ""
+	if !explicit {
+		file = defaultTrustedKeys()
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read trusted keys: %v", err)
+	}
+
+	var keys []trustedKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		key, ok := parsePublicKey(line)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: not an ed25519 or minisign public key", file, n)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+func parsePublicKey(s string) (trustedKey, bool) {
+	if raw, err := hex.DecodeString(s); err == nil && len(raw) == ed25519.PublicKeySize {
+		return trustedKey{key: raw}, true
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return trustedKey{}, false
+	}
+	switch {
+	case len(raw) == ed25519.PublicKeySize:
+		return trustedKey{key: raw}, true
+	case len(raw) == 42 && string(raw[:2]) == "Ed":
+		return trustedKey{id: raw[2:10], key: raw[10:]}, true
+	}
+	return trustedKey{}, false
+}
+
+// isEd25519Signature 判断签名文件是否为 minisign 格式，或 64 字节的原始 ed25519 签名（二进制或 base64）
+func isEd25519Signature(data []byte) bool {
+	return strings.HasPrefix(string(data), "untrusted comment:") || rawSignature(data) != nil
+}
+
+func rawSignature(data []byte) []byte {
+	if len(data) == ed25519.SignatureSize {
+		return data
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil
+	}
+	return sig
+}
+
+// verifySignature 用受信任的公钥校验 archive 的签名文件内容 data，返回签名者的名称
+func verifySignature(archive string, data []byte, keys []trustedKey) (string, error) {
+	if strings.HasPrefix(string(data), "untrusted comment:") {
+		return verifyMinisign(archive, data, keys)
+	}
+
+	// 原始 ed25519 签名的签名对象是归档本身
+	sig := rawSignature(data)
+	content, err := readUnhashedSigned(archive)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range keys {
+		if ed25519.Verify(k.key, content, sig) {
+			return k.name(), nil
+		}
+	}
+	return "", fmt.Errorf("not signed by any trusted key")
+}
+
+// verifyMinisign 校验 minisign 签名：第二行为 "Ed"/"ED" + key id + 签名，"ED" 表示签名对象是文件的 BLAKE2b-512；
+// 第四行是对签名与 trusted comment 的全局签名
+func verifyMinisign(archive string, data []byte, keys []trustedKey) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", fmt.Errorf("malformed minisign signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", fmt.Errorf("malformed minisign global signature")
+	}
+	algorithm, id, signature := string(sig[:2]), sig[2:10], sig[10:]
+
+	var key *trustedKey
+	for i := range keys {
+		if keys[i].id != nil && bytes.Equal(keys[i].id, id) {
+			key = &keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return "", fmt.Errorf("signed by unknown key %016X", binary.LittleEndian.Uint64(id))
+	}
+
+	var message []byte
+	switch algorithm {
+	case "Ed":
+		if message, err = readUnhashedSigned(archive); err != nil {
+			return "", err
+		}
+	case "ED":
+		if message, err = sumFile(archive, newBlake2b()); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported minisign signature algorithm")
+	}
+	if !ed25519.Verify(key.key, message, signature) {
+		return "", fmt.Errorf("signature does not match")
+	}
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(key.key, append(append([]byte(nil), signature...), comment...), global) {
+		return "", fmt.Errorf("trusted comment signature does not match")
+	}
+	return key.name(), nil
+}
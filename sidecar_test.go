@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeMinisig 按 minisign 的预哈希格式（"ED"）为 archive 写出 .minisig
+func writeMinisig(t *testing.T, archive string, id []byte, priv ed25519.PrivateKey) {
+	h := newBlake2b()
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write(data)
+	sig := ed25519.Sign(priv, h.Sum(nil))
+	comment := "timestamp:0"
+	global := ed25519.Sign(priv, append(append([]byte(nil), sig...), comment...))
+	content := "untrusted comment: test\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("ED"), id...), sig...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+	if err := os.WriteFile(archive+".minisig", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSidecarRoundTrip(t *testing.T) {
+	tmp := t.TempDir()
+	archive := filepath.Join(tmp, "a.tar")
+	data := []byte("archive contents")
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if err := os.WriteFile(archive+".sha256", []byte(hex.EncodeToString(sum[:])+"  a.tar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	keys := filepath.Join(tmp, "trusted-keys")
+	minisignKey := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), pub...))
+	if err := os.WriteFile(keys, []byte("# test\n"+minisignKey+"\n"+hex.EncodeToString(pub)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeMinisig(t, archive, id, priv)
+	raw := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+	if err := os.WriteFile(archive+".sig", []byte(raw+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vo := &VerifyOptions{Enabled: true, Require: true, TrustedKeys: keys}
+	if err := verifySidecars(archive, vo); err != nil {
+		t.Fatal(err)
+	}
+
+	// 归档被改动后校验和与签名都不再匹配
+	os.Remove(archive + ".sha256")
+	if err := os.WriteFile(archive, []byte("tampered contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySidecars(archive, vo); err == nil {
+		t.Fatal("tampered archive passed verification")
+	}
+}
+
+func TestUnhashedSignatureSizeCap(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "big.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 稀疏文件，不会真正占用磁盘
+	err = f.Truncate(maxUnhashedSigned + 1)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readUnhashedSigned(archive); err == nil {
+		t.Fatal("expected archives above the cap to be refused")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ============== 裸压缩流后端 ==============
+
+// streamFormat 处理内部不是 tar 的单流压缩文件，如 foo.log.gz
+type streamFormat struct {
+	codec *codec
+}
+
+func (s *streamFormat) Name() archiveKind { return s.codec.kind }
+
+func (s *streamFormat) Extensions() []string { return s.codec.extensions }
+
+func (s *streamFormat) Detect(p *probe) bool {
+	return p.hasMagic(magicSignature{0, s.codec.magic}) && !p.compressedTar(s.codec)
+}
+
+func (s *streamFormat) Capabilities() Capability {
+	caps := CapList | CapExtract
+	if s.codec.reader != nil {
+		caps |= CapNative
+	}
+	return caps
+}
+
+// memberName 返回压缩流内唯一文件的名称与修改时间：gzip 头中记录了原文件名时优先使用
+func (s *streamFormat) memberName(archive string) (string, time.Time) {
+	name := strings.TrimSuffix(filepath.Base(archive), filepath.Ext(archive))
+	var mtime time.Time
+	if s.codec.kind == "gz" {
+		if f, err := os.Open(archive); err == nil {
+			if zr, err := gzip.NewReader(f); err == nil {
+				if zr.Name != "" {
+					name = filepath.Base(zr.Name)
+				}
+				mtime = zr.ModTime
+			}
+			f.Close()
+		}
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "data"
+	}
+	return name, mtime
+}
+
+func (s *streamFormat) List(archive string) ([]Entry, error) {
+	name, mtime := s.memberName(archive)
+	e := Entry{Name: name, Size: -1, Packed: -1, Mode: 0644, ModTime: mtime}
+	if fi, err := os.Stat(archive); err == nil {
+		e.Packed = fi.Size()
+		if mtime.IsZero() {
+			e.ModTime = fi.ModTime()
+		}
+	}
+	// gzip 尾部记录了 CRC32 与原始大小（对 4GiB 取模），足以用于展示
+	if s.codec.kind == "gz" {
+		if f, err := os.Open(archive); err == nil {
+			var trailer [8]byte
+			if _, err := f.ReadAt(trailer[:], e.Packed-8); err == nil {
+				e.CRC, e.HasCRC = binary.LittleEndian.Uint32(trailer[:4]), true
+				e.Size = int64(binary.LittleEndian.Uint32(trailer[4:]))
+			}
+			f.Close()
+		}
+	}
+	if rc, err := openDecompressed(archive, s.codec); err == nil {
+		e.IsArchive = sniffEntry(name, readHead(rc))
+		closeEarly(rc)
+	}
+	return []Entry{e}, nil
+}
+
+func (s *streamFormat) Open(archive, name string) (io.ReadCloser, error) {
+	return openDecompressed(archive, s.codec)
+}
+
+func (s *streamFormat) Extract(archive, dest string, opts *Options) error {
+	rc, err := openDecompressed(archive, s.codec)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	name, mtime := s.memberName(archive)
+	root, err := newExtractRoot(archive, dest, opts)
+	if err != nil {
+		return err
+	}
+	target, err := root.target(name)
+	if err != nil {
+		return err
+	}
+	if err := root.addEntry(); err != nil {
+		return err
+	}
+	return root.writeFile(target, rc, 0644, mtime)
+}
+
+// Test 解压整个流并丢弃，gzip 由尾部的 CRC32 校验，其余格式由外部命令自行校验
+func (s *streamFormat) Test(archive string) ([]EntryFault, error) {
+	rc, err := openDecompressed(archive, s.codec)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(io.Discard, rc)
+	if cerr := rc.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		name, _ := s.memberName(archive)
+		return []EntryFault{{Name: name, Err: err}}, nil
+	}
+	return nil, nil
+}
+
+func (s *streamFormat) Create(archive, sourceDir string) error {
+	return fmt.Errorf("%s is a single-file compression format and cannot hold a directory", s.codec.kind)
+}
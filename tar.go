@@ -0,0 +1,492 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ============== tar 后端 ==============
+
+// tarFormat 处理 tar 以及外层套了一层压缩流的 tar，codec 为 nil 时表示未压缩
+type tarFormat struct {
+	codec *codec
+}
+
+func init() {
+	registerFormat(&tarFormat{})
+}
+
+func (t *tarFormat) Name() archiveKind {
+	if t.codec == nil {
+		return kindTar
+	}
+	return t.codec.tarKind
+}
+
+func (t *tarFormat) Extensions() []string {
+	if t.codec == nil {
+		return []string{".tar"}
+	}
+	return t.codec.tarExtensions
+}
+
+func (t *tarFormat) Detect(p *probe) bool {
+	if t.codec == nil {
+		return isTarHeader(p.header)
+	}
+	return p.compressedTar(t.codec)
+}
+
+func (t *tarFormat) Capabilities() Capability {
+	caps := CapList | CapExtract | CapCreate
+	if t.codec == nil || (t.codec.reader != nil && t.codec.writer != nil) {
+		caps |= CapNative
+	}
+	return caps
+}
+
+// walkTar 依次回调 tar 中的每个条目，回调可以从 tr 读取当前条目的内容
+func (t *tarFormat) walkTar(archive string, fn func(hdr *tar.Header, tr *tar.Reader) error) error {
+	rc, err := openDecompressed(archive, t.codec)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rc.Close()
+			return fmt.Errorf("invalid tar stream: %v", err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			rc.Close()
+			return err
+		}
+	}
+	// tar 结束标记之后可能还有填充数据，读完再关闭，避免外部解压命令因管道断开而报错。
+	// 压缩流的校验和位于末尾，此时才会检查
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		rc.Close()
+		return fmt.Errorf("invalid %s stream: %v", t.Name(), err)
+	}
+	return rc.Close()
+}
+
+func (t *tarFormat) List(archive string) ([]Entry, error) {
+	var entries []Entry
+	err := t.walkTar(archive, func(hdr *tar.Header, tr *tar.Reader) error {
+		e := tarEntry(hdr)
+		if e.Name == "" || e.Name == "." {
+			return nil
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			e.IsArchive = sniffEntry(e.Name, readHead(tr))
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// tarEntry 把 tar 头转换为 Entry，硬链接表现为带 Linkname 的普通文件
+func tarEntry(hdr *tar.Header) Entry {
+	return Entry{
+		Name:     strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "./"), "/"),
+		Size:     hdr.Size,
+		Packed:   -1,
+		Mode:     hdr.FileInfo().Mode(),
+		ModTime:  hdr.ModTime,
+		Linkname: hdr.Linkname,
+		Owner:    tarOwner(hdr),
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+	}
+}
+
+// Walk 顺序读出全部条目，用于格式转换
+func (t *tarFormat) Walk(archive string, fn func(e *Entry, r io.Reader) error) error {
+	return t.walkTar(archive, func(hdr *tar.Header, tr *tar.Reader) error {
+		e := tarEntry(hdr)
+		if e.Name == "" || e.Name == "." {
+			return nil
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return fn(&e, tr)
+		}
+		return fn(&e, nil)
+	})
+}
+
+// tarOwner 优先使用头部记录的用户名与组名，缺失时退回数字 ID
+func tarOwner(hdr *tar.Header) string {
+	user, group := hdr.Uname, hdr.Gname
+	if user == "" {
+		user = strconv.Itoa(hdr.Uid)
+	}
+	if group == "" {
+		group = strconv.Itoa(hdr.Gid)
+	}
+	return user + "/" + group
+}
+
+func (t *tarFormat) Open(archive, name string) (io.ReadCloser, error) {
+	rc, err := openDecompressed(archive, t.codec)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("invalid tar stream: %v", err)
+		}
+		if strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "./"), "/") == name && hdr.Typeflag == tar.TypeReg {
+			return streamReader{tr, rc}, nil
+		}
+	}
+	rc.Close()
+	return nil, fmt.Errorf("'%s' not found in %s", name, filepath.Base(archive))
+}
+
+func (t *tarFormat) Extract(archive, dest string, opts *Options) error {
+	root, err := newExtractRoot(archive, dest, opts)
+	if err != nil {
+		return err
+	}
+	modes := dirModes{}
+	defer modes.restore()
+	dirs := dirTimes{}
+	defer dirs.restore()
+
+	return t.walkTar(archive, func(hdr *tar.Header, tr *tar.Reader) error {
+		target, err := root.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := root.addEntry(); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			restoreOwner(target, hdr)
+			modes[target] = hdr.FileInfo().Mode().Perm()
+			dirs[target] = hdr.ModTime
+		case tar.TypeReg:
+			if err := root.writeFile(target, tr, hdr.FileInfo().Mode().Perm(), hdr.ModTime); err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			restoreOwner(target, hdr)
+		case tar.TypeSymlink:
+			if err := root.checkSymlink(hdr.Name, target, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := writeSymlink(target, hdr.Linkname); err != nil {
+				return err
+			}
+			restoreOwner(target, hdr)
+		case tar.TypeLink:
+			linkTarget, err := root.target(hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Link(linkTarget, target)
+		default:
+			// 设备文件、FIFO 等特殊条目无法在普通用户下还原，直接跳过
+		}
+		return nil
+	})
+}
+
+// originalLevel 推断原压缩流的压缩级别（gzip 的 XFL、bzip2 的块大小），其余编解码器无法从文件推断，使用默认级别
+func (t *tarFormat) originalLevel(original string) int {
+	if t.codec == nil || t.codec.level == nil {
+		return 0
+	}
+	p, err := newProbe(original)
+	if err != nil {
+		return 0
+	}
+	return t.codec.level(p.header)
+}
+
+// tarWriter 打开 archive 并返回写入 tar 的 Writer；finish 依次关闭 tar、压缩流与文件，返回第一个错误
+func (t *tarFormat) tarWriter(archive string, level, threads int) (tw *tar.Writer, finish func(err error) error, err error) {
+	out, err := os.Create(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var w io.WriteCloser = nopWriteCloser{out}
+	if t.codec != nil {
+		if w, err = newCompressor(out, t.codec, level, threads); err != nil {
+			out.Close()
+			return nil, nil, err
+		}
+	}
+	tw = tar.NewWriter(w)
+	finish = func(err error) error {
+		if err == nil {
+			err = tw.Close()
+		}
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return tw, finish, nil
+}
+
+func (t *tarFormat) Create(archive, sourceDir string) error {
+	tw, finish, err := t.tarWriter(archive, 0, 0)
+	if err != nil {
+		return err
+	}
+	return finish(walkSourceDir(sourceDir, func(name, path string, fi os.FileInfo) error {
+		return writeTarEntry(tw, name, path, fi)
+	}))
+}
+
+// Build 按 adds 的顺序写出新的 tar 归档
+func (t *tarFormat) Build(archive string, adds []EditAdd, params *CreateParams) error {
+	tw, finish, err := t.tarWriter(archive, params.Level, params.Threads)
+	if err != nil {
+		return err
+	}
+	for _, add := range adds {
+		var fi os.FileInfo
+		if fi, err = os.Lstat(add.Path); err != nil {
+			break
+		}
+		if err = writeTarEntry(tw, add.Name, add.Path, fi); err != nil {
+			break
+		}
+	}
+	return finish(err)
+}
+
+// tarEntryWriter 把数据流中的条目写入 tar，用于格式转换
+type tarEntryWriter struct {
+	tw     *tar.Writer
+	finish func(err error) error
+}
+
+func (t *tarFormat) NewEntryWriter(archive string, params *CreateParams) (EntryWriter, error) {
+	tw, finish, err := t.tarWriter(archive, params.Level, params.Threads)
+	if err != nil {
+		return nil, err
+	}
+	return &tarEntryWriter{tw: tw, finish: finish}, nil
+}
+
+// WriteEntry 写入目录、普通文件、符号链接与硬链接，其余特殊条目跳过
+func (w *tarEntryWriter) WriteEntry(e *Entry, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    e.Name,
+		Mode:    tarMode(e.Mode),
+		ModTime: e.ModTime,
+		Uid:     e.Uid,
+		Gid:     e.Gid,
+	}
+	// Owner 中的数字 ID 已记录在 Uid / Gid 中，只保留名字
+	if user, group, ok := strings.Cut(e.Owner, "/"); ok {
+		if _, err := strconv.Atoi(user); err != nil {
+			hdr.Uname = user
+		}
+		if _, err := strconv.Atoi(group); err != nil {
+			hdr.Gname = group
+		}
+	}
+	switch {
+	case e.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case e.Mode&os.ModeSymlink != 0:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = e.Linkname
+	case e.Mode.IsRegular() && e.Linkname != "":
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = e.Linkname
+	case e.Mode.IsRegular():
+		if e.Size < 0 {
+			return fmt.Errorf("%s: size is unknown", e.Name)
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = e.Size
+	default:
+		return nil
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarEntryWriter) Close() error { return w.finish(nil) }
+
+// tarMode 把 os.FileMode 转换为 tar 头中的权限位，含 setuid / setgid / sticky
+func tarMode(mode os.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// Edit 顺序读取原归档，原样复制保留的条目头与内容；替换的条目写在原条目的位置，其余新增的条目追加在最后
+func (t *tarFormat) Edit(original, archive string, edit *ArchiveEdit) error {
+	tw, finish, err := t.tarWriter(archive, t.originalLevel(original), 0)
+	if err != nil {
+		return err
+	}
+	written := make(map[string]bool)
+	err = t.walkTar(original, func(hdr *tar.Header, tr *tar.Reader) error {
+		name := cleanEntryName(hdr.Name)
+		if edit.replaces(name) {
+			// 同名条目出现多次时只在第一次出现的位置写入
+			if written[name] {
+				return nil
+			}
+			written[name] = true
+			for _, add := range edit.Adds {
+				if add.Name == name {
+					return replaceTarEntry(tw, hdr, add)
+				}
+			}
+		}
+		if edit.removes(name) {
+			return nil
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, tr)
+		return err
+	})
+	for _, add := range edit.Adds {
+		if err != nil {
+			break
+		}
+		if written[add.Name] {
+			continue
+		}
+		var fi os.FileInfo
+		if fi, err = os.Lstat(add.Path); err != nil {
+			break
+		}
+		err = writeTarEntry(tw, add.Name, add.Path, fi)
+	}
+	return finish(err)
+}
+
+// replaceTarEntry 在原条目 orig 的位置写入替换它的 add。KeepMeta 且两者都是普通文件时只替换数据，
+// 条目名、权限、时间、属主与头格式都沿用原条目
+func replaceTarEntry(tw *tar.Writer, orig *tar.Header, add EditAdd) error {
+	fi, err := os.Lstat(add.Path)
+	if err != nil {
+		return err
+	}
+	if !add.KeepMeta || orig.Typeflag != tar.TypeReg || !fi.Mode().IsRegular() {
+		return writeTarEntry(tw, add.Name, add.Path, fi)
+	}
+	hdr := *orig
+	hdr.Size = fi.Size()
+	// PAX 记录中的 size 以原内容为准，交给 Writer 按新的 Size 重新生成
+	if _, ok := hdr.PAXRecords["size"]; ok {
+		hdr.PAXRecords = make(map[string]string)
+		for k, v := range orig.PAXRecords {
+			if k != "size" {
+				hdr.PAXRecords[k] = v
+			}
+		}
+	}
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	in, err := os.Open(add.Path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(tw, in)
+	return err
+}
+
+// writeTarEntry 写入一个文件、目录或符号链接，其余特殊文件跳过
+func writeTarEntry(tw *tar.Writer, name, path string, fi os.FileInfo) error {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	} else if !fi.IsDir() && !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(tw, in)
+	return err
+}
+
+// restoreOwner 以 root 身份运行时还原条目的属主，普通用户无权修改属主，保持为当前用户
+func restoreOwner(target string, hdr *tar.Header) {
+	if os.Geteuid() == 0 {
+		os.Lchown(target, hdr.Uid, hdr.Gid)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNestedEditKeepsTarEntryInPlace(t *testing.T) {
+	tmp := t.TempDir()
+	var inner bytes.Buffer
+	zw := zip.NewWriter(&inner)
+	w, _ := zw.Create("x.txt")
+	w.Write([]byte("x"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	archive := filepath.Join(tmp, "outer.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	entries := []struct {
+		name string
+		data []byte
+	}{{"./inner.zip", inner.Bytes()}, {"./other.txt", []byte("other")}}
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), ModTime: mtime,
+			Uid: 1234, Gid: 99, Typeflag: tar.TypeReg, Format: tar.FormatUSTAR}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(e.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	added := filepath.Join(tmp, "new.txt")
+	if err := os.WriteFile(added, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = addFilesToArchive(archive, []string{added}, &AddOptions{Into: "inner.zip!/"}, &Options{Limits: defaultLimits()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for i, want := range entries {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		// 替换的条目除数据外应与原条目一致，且仍在原来的位置
+		if hdr.Name != want.name || !hdr.ModTime.Equal(mtime) || hdr.Uid != 1234 || hdr.Gid != 99 {
+			t.Errorf("entry %d changed: %s %v %d/%d", i, hdr.Name, hdr.ModTime, hdr.Uid, hdr.Gid)
+		}
+		if i == 0 {
+			data, _ := io.ReadAll(tr)
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(zr.File) != 2 {
+				t.Errorf("inner.zip has %d entries, want 2", len(zr.File))
+			}
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("unexpected extra entry: %v", err)
+	}
+}
+
+func TestReadOnlyTarDirectoryKeepsChildren(t *testing.T) {
+	tmp := t.TempDir()
+	archive := filepath.Join(tmp, "ro.tar")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	tw.WriteHeader(&tar.Header{Name: "ro/", Mode: 0555, Typeflag: tar.TypeDir, ModTime: time.Now()})
+	tw.WriteHeader(&tar.Header{Name: "ro/file.txt", Mode: 0644, Size: 4, Typeflag: tar.TypeReg, ModTime: time.Now()})
+	tw.Write([]byte("data"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dest := filepath.Join(tmp, "out")
+	// 只读目录会挡住 TempDir 的清理，结束时先恢复写权限
+	t.Cleanup(func() { os.Chmod(filepath.Join(dest, "ro"), 0755) })
+	if err := (&tarFormat{}).Extract(archive, dest, &Options{Limits: defaultLimits()}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "ro", "file.txt")); err != nil || string(data) != "data" {
+		t.Fatalf("file.txt = %q, %v", data, err)
+	}
+	fi, err := os.Stat(filepath.Join(dest, "ro"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0555 {
+		t.Errorf("ro mode = %v, want 0555", fi.Mode().Perm())
+	}
+}
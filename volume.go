@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ============== 分卷归档 ==============
+
+// volumeStyle 是分卷的命名方式
+type volumeStyle int
+
+const (
+	volSingle   volumeStyle = iota // 不是分卷
+	volRarPart                     // foo.part1.rar、foo.part2.rar ...
+	volRarOld                      // foo.rar、foo.r00、foo.r01 ...
+	volNumbered                    // foo.7z.001、foo.7z.002 ...（7z -v 或 split -d -a 3）
+	volZip                         // foo.z01、foo.z02 ... foo.zip（zip -s）
+	volSplit                       // foo.tar.gz.aa、foo.tar.gz.ab ...（split 的默认后缀）
+)
+
+// volumeName 是从一个文件名解析出的分卷信息
+type volumeName struct {
+	style volumeStyle
+	base  string // 去掉卷号部分后的文件名，同一组的各卷相同
+	index int    // 卷号，从 0 开始
+	width int    // 卷号的位数，补齐缺失卷的名称时使用
+}
+
+// parseVolumeName 判断 name（不含目录）是否是某种分卷的一卷
+func parseVolumeName(name string) (volumeName, bool) {
+	lower := strings.ToLower(name)
+	ext := filepath.Ext(lower)
+
+	switch {
+	case ext == ".rar":
+		stem := lower[:len(lower)-4]
+		if i := strings.LastIndex(stem, ".part"); i > 0 {
+			num := stem[i+5:]
+			if n, err := strconv.Atoi(num); err == nil && n > 0 && isDigits(num) {
+				return volumeName{volRarPart, name[:i], n - 1, len(num)}, true
+			}
+		}
+		return volumeName{volRarOld, name[:len(name)-4], 0, 2}, true
+	case len(ext) == 4 && ext[1] == 'r' && isDigits(ext[2:]):
+		n, _ := strconv.Atoi(ext[2:])
+		return volumeName{volRarOld, name[:len(name)-4], n + 1, 2}, true
+	case ext == ".zip":
+		return volumeName{volZip, name[:len(name)-4], -1, 2}, true
+	case len(ext) >= 4 && ext[1] == 'z' && isDigits(ext[2:]):
+		n, _ := strconv.Atoi(ext[2:])
+		if n > 0 {
+			return volumeName{volZip, name[:len(name)-len(ext)], n - 1, len(ext) - 2}, true
+		}
+	case len(ext) == 4 && isDigits(ext[1:]):
+		// 去掉卷号后须是归档名，否则 report.001 这类普通文件也会被当作分卷
+		n, _ := strconv.Atoi(ext[1:])
+		if base := name[:len(name)-4]; n > 0 && formatFromName(base) != nil {
+			return volumeName{volNumbered, base, n - 1, 3}, true
+		}
+	case len(ext) == 3 && isLowerAlpha(ext[1:]):
+		// ".gz"、".xz" 这样的扩展名同样是两个字母，只有去掉后缀后仍是归档名、且整体不是归档名时才算切分
+		base := name[:len(name)-3]
+		if formatFromName(name) == nil && formatFromName(base) != nil {
+			return volumeName{volSplit, base, int(ext[1]-'a')*26 + int(ext[2]-'a'), 2}, true
+		}
+	}
+	return volumeName{}, false
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isLowerAlpha(s string) bool {
+	for _, c := range s {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// volumeFile 按命名方式生成第 index 卷的文件名，用于列出缺失的卷
+func volumeFile(style volumeStyle, base string, index, width int) string {
+	switch style {
+	case volRarPart:
+		return fmt.Sprintf("%s.part%0*d.rar", base, width, index+1)
+	case volRarOld:
+		if index == 0 {
+			return base + ".rar"
+		}
+		return fmt.Sprintf("%s.r%02d", base, index-1)
+	case volNumbered:
+		return fmt.Sprintf("%s.%03d", base, index+1)
+	case volZip:
+		if index < 0 {
+			return base + ".zip"
+		}
+		return fmt.Sprintf("%s.z%0*d", base, width, index+1)
+	case volSplit:
+		return fmt.Sprintf("%s.%c%c", base, 'a'+index/26, 'a'+index%26)
+	}
+	return base
+}
+
+// volumeSet 是一组分卷；不是分卷的普通归档也表示为只有一卷的 volumeSet
+type volumeSet struct {
+	style   volumeStyle
+	name    string   // 整组的名称，如 foo.rar、foo.7z、foo.zip、foo.tar.gz，决定解压目录
+	first   string   // 交给后端打开的卷
+	parts   []string // 找到的全部卷，按卷号排列
+	missing []string // 缺少的卷
+	join    bool     // 原始切分，要先拼接成完整文件才能交给后端
+}
+
+func (s *volumeSet) String() string {
+	if len(s.parts) == 1 && len(s.missing) == 0 {
+		return s.first
+	}
+	return fmt.Sprintf("%s (%s)", s.name, plural(len(s.parts)+len(s.missing), "volume"))
+}
+
+// findVolumes 从 file 所在目录中找出与它同组的全部分卷。file 不存在时不去找同组的其他卷，
+// 原样返回，由调用方报告文件不存在
+func findVolumes(file string) *volumeSet {
+	single := &volumeSet{name: file, first: file, parts: []string{file}}
+	if _, err := os.Stat(file); err != nil {
+		return single
+	}
+	dir, name := filepath.Split(file)
+	vol, ok := parseVolumeName(name)
+	if !ok {
+		return single
+	}
+	entries, err := os.ReadDir(filepath.Clean(dir + "."))
+	if err != nil {
+		return single
+	}
+
+	// 同组的卷按卷号收集；zip 的 .zip 是最后一卷，单独记录
+	found := make(map[int]string)
+	last := ""
+	width := vol.width
+	for _, entry := range entries {
+		v, ok := parseVolumeName(entry.Name())
+		if !ok || v.style != vol.style || v.base != vol.base || entry.IsDir() {
+			continue
+		}
+		if v.index < 0 {
+			last = dir + entry.Name()
+			continue
+		}
+		found[v.index] = dir + entry.Name()
+		if v.style == volRarPart {
+			width = v.width
+		}
+	}
+
+	count := 0
+	for index := range found {
+		count = max(count, index+1)
+	}
+	switch vol.style {
+	case volRarOld:
+		// 没有 .r00 的 .rar 是普通归档
+		if count < 2 {
+			return single
+		}
+	case volZip:
+		// .zip 的结尾目录记录了它是第几卷，据此能知道前面应有多少个 .zNN；
+		// 记录为第 0 卷的是普通 zip，旁边多出的 .z01 与它无关
+		if last != "" {
+			count = zipDiskNumber(last)
+		}
+		if count == 0 {
+			return single
+		}
+	}
+
+	set := &volumeSet{style: vol.style, name: dir + vol.base}
+	switch vol.style {
+	case volRarPart:
+		set.name += ".rar"
+	case volRarOld:
+		set.name = dir + volumeFile(volRarOld, vol.base, 0, 2)
+	case volZip:
+		set.name += ".zip"
+	}
+	for index := 0; index < count; index++ {
+		if part, ok := found[index]; ok {
+			set.parts = append(set.parts, part)
+		} else {
+			set.missing = append(set.missing, volumeFile(vol.style, vol.base, index, width))
+		}
+	}
+	set.first = found[0]
+	if vol.style == volZip {
+		// 7z 从最后一卷（.zip）的中央目录读起
+		set.first = last
+		if last == "" {
+			set.missing = append(set.missing, vol.base+".zip")
+		} else {
+			set.parts = append(set.parts, last)
+		}
+	}
+
+	switch vol.style {
+	case volSplit:
+		set.join = true
+	case volNumbered:
+		// 交给 7z 的格式能直接读取 .001，其余格式（以及缺少 7z 时改用 unrar 的 rar）需要完整的文件
+		if set.first != "" {
+			format, err := lookupFormat(set.first)
+			_, by7z := format.(*externalFormat)
+			set.join = err != nil || !by7z || !commandExists("7z")
+		}
+	}
+	return set
+}
+
+// zipDiskNumber 读取 zip 结尾目录记录中的当前卷号，普通 zip 为 0
+func zipDiskNumber(file string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	_, rec, err := readZipEnd(f)
+	if err != nil {
+		return 0
+	}
+	disk := int(binary.LittleEndian.Uint16(rec[4:]))
+	if disk == 0xffff {
+		return 0
+	}
+	return disk
+}
+
+// readZipEnd 找到 zip 的结尾目录记录，返回它在文件中的位置与固定长度的 22 字节
+func readZipEnd(f *os.File) (int64, []byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	// 结尾目录记录 22 字节，之后最多还有 65535 字节的注释
+	size := min(fi.Size(), 22+65535)
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, fi.Size()-size); err != nil {
+		return 0, nil, err
+	}
+	i := bytes.LastIndex(buf, []byte("PK\x05\x06"))
+	if i < 0 || i+22 > len(buf) {
+		return 0, nil, fmt.Errorf("'%s' has no zip end record", filepath.Base(f.Name()))
+	}
+	return fi.Size() - size + int64(i), buf[i : i+22], nil
+}
+
+// groupVolumes 把命令行给出的文件按分卷组合并：同组的卷只处理一次，只给出其中一卷时也会找齐其余各卷
+func groupVolumes(files []string) []*volumeSet {
+	var sets []*volumeSet
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if seen[filepath.Clean(file)] {
+			continue
+		}
+		set := findVolumes(file)
+		for _, part := range set.parts {
+			seen[filepath.Clean(part)] = true
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// check 确认分卷齐全，缺卷时列出缺少的卷名
+func (s *volumeSet) check() error {
+	if len(s.missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s is incomplete, missing %s: %s", filepath.Base(s.name), plural(len(s.missing), "volume"), strings.Join(s.missing, ", "))
+}
+
+// extract 把整组分卷解压到 dest
+func (s *volumeSet) extract(dest string, opts *Options) error {
+	file, cleanup, err := s.whole()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return extractArchive(file, dest, opts)
+}
+
+// whole 返回能直接交给后端读取的完整归档：原始切分先拼接，跨卷 zip 合并为普通 zip，
+// 其余分卷由 7z 自己读取，返回第一卷。cleanup 删除合并时产生的临时文件
+func (s *volumeSet) whole() (string, func(), error) {
+	if !s.join && s.style != volZip {
+		return s.first, func() {}, nil
+	}
+	tmpdir, err := createTempDir("ub_join_")
+	if err != nil {
+		return "", nil, err
+	}
+	joined := filepath.Join(tmpdir, filepath.Base(s.name))
+	if s.join {
+		err = joinFiles(joined, s.parts)
+	} else {
+		err = joinZip(joined, s.parts)
+	}
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return "", nil, err
+	}
+	return joined, func() { os.RemoveAll(tmpdir) }, nil
+}
+
+// joinZip 把跨卷 zip 的各卷（.z01 ... .zip）拼接为普通 zip。各卷中记录的偏移都相对于所在卷的开头，
+// 拼接后把中央目录与结尾目录记录中的卷号清零、偏移改为相对于整个文件。
+// 不用 zip -s 0 合并：Info-ZIP 3.0 遇到跨卷的条目时会丢掉数据
+func joinZip(out string, parts []string) error {
+	if err := joinFiles(out, parts); err != nil {
+		return err
+	}
+	if err := fixJoinedZip(out, parts); err != nil {
+		os.Remove(out)
+		return fmt.Errorf("failed to join %s: %v", filepath.Base(parts[len(parts)-1]), err)
+	}
+	return nil
+}
+
+// fixJoinedZip 改写拼接后的中央目录与结尾目录记录
+func fixJoinedZip(file string, parts []string) error {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// 各卷在拼接结果中的起始位置
+	starts := make([]int64, len(parts))
+	var pos int64
+	for i, part := range parts {
+		fi, err := os.Stat(part)
+		if err != nil {
+			return err
+		}
+		starts[i] = pos
+		pos += fi.Size()
+	}
+
+	endPos, rec, err := readZipEnd(f)
+	if err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	disk, cdDisk := int(le.Uint16(rec[4:])), int(le.Uint16(rec[6:]))
+	total, cdSize, cdOffset := le.Uint16(rec[10:]), le.Uint32(rec[12:]), le.Uint32(rec[16:])
+	if disk == 0xffff || total == 0xffff || cdSize == 0xffffffff || cdOffset == 0xffffffff {
+		return fmt.Errorf("split zip64 archives are not supported")
+	}
+	if disk != len(parts)-1 || cdDisk > disk {
+		return fmt.Errorf("the zip end record expects %d volumes, found %d", disk+1, len(parts))
+	}
+
+	cdStart := starts[cdDisk] + int64(cdOffset)
+	cd := make([]byte, cdSize)
+	if _, err := f.ReadAt(cd, cdStart); err != nil {
+		return err
+	}
+	// 中央目录的每条记录固定 46 字节，之后是文件名、扩展字段与注释
+	for p, n := 0, 0; n < int(total); n++ {
+		if p+46 > len(cd) || le.Uint32(cd[p:]) != 0x02014b50 {
+			return fmt.Errorf("corrupt central directory")
+		}
+		d, offset := le.Uint16(cd[p+34:]), le.Uint32(cd[p+42:])
+		if d == 0xffff || offset == 0xffffffff {
+			return fmt.Errorf("split zip64 archives are not supported")
+		}
+		if int(d) >= len(parts) {
+			return fmt.Errorf("corrupt central directory")
+		}
+		le.PutUint16(cd[p+34:], 0)
+		le.PutUint32(cd[p+42:], uint32(starts[d]+int64(offset)))
+		p += 46 + int(le.Uint16(cd[p+28:])) + int(le.Uint16(cd[p+30:])) + int(le.Uint16(cd[p+32:]))
+	}
+	if _, err := f.WriteAt(cd, cdStart); err != nil {
+		return err
+	}
+
+	le.PutUint16(rec[4:], 0)
+	le.PutUint16(rec[6:], 0)
+	le.PutUint16(rec[8:], total)
+	le.PutUint32(rec[16:], uint32(cdStart))
+	_, err = f.WriteAt(rec, endPos)
+	return err
+}
+
+// withVolumes 找齐 file 所在分卷组的其余各卷，把可直接读取的完整归档交给 fn；不是分卷时就是 file 本身
+func withVolumes(file string, fn func(archive string) error) error {
+	set := findVolumes(file)
+	if err := set.check(); err != nil {
+		return err
+	}
+	whole, cleanup, err := set.whole()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return fn(whole)
+}
+
+// joinFiles 按顺序把 parts 拼接为 out
+func joinFiles(out string, parts []string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		var in *os.File
+		if in, err = os.Open(part); err != nil {
+			break
+		}
+		_, err = io.Copy(f, in)
+		in.Close()
+		if err != nil {
+			break
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+	}
+	return err
+}
+
+// volumeLimits 是各命名方式最多能编号的卷数
+var volumeLimits = map[volumeStyle]int{volNumbered: 999, volSplit: 26 * 26}
+
+// volumeStyleFor 选择写出分卷的方式：7z 与 7z -v 一样按字节切为 .001，zip 交给 zip -s 写成 .z01 + .zip，
+// tar 等数据流切为 split 风格的 .aa
+func volumeStyleFor(archive string) volumeStyle {
+	if format := formatFromName(archive); format != nil {
+		switch format.Name() {
+		case kind7z:
+			return volNumbered
+		case kindZip:
+			return volZip
+		}
+	}
+	return volSplit
+}
+
+// checkVolumesFree 在写出归档前确认分卷的文件名没有被占用
+func checkVolumesFree(archive string) error {
+	style := volumeStyleFor(archive)
+	first := volumeFile(style, archive, 0, 2)
+	if style == volZip {
+		first = volumeFile(style, strings.TrimSuffix(archive, filepath.Ext(archive)), 0, 2)
+	}
+	if _, err := os.Lstat(first); err == nil {
+		return fmt.Errorf("'%s' already exists", filepath.Base(first))
+	}
+	return nil
+}
+
+// writeVolumes 把已写好的 archive 拆分为每卷不超过 size 字节的分卷。
+// zip 的最后一卷仍是 archive 本身，其余格式拆分成功后删除 archive
+func writeVolumes(archive string, size int64) error {
+	style := volumeStyleFor(archive)
+	var parts []string
+	var err error
+	if style == volZip {
+		parts, err = splitZip(archive, size)
+	} else {
+		parts, err = splitFile(archive, size, style)
+		if err == nil {
+			err = os.Remove(archive)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to split %s into volumes: %v", filepath.Base(archive), err)
+	}
+	fmt.Printf("Split %s into %s of up to %s:\n", filepath.Base(archive), plural(len(parts), "volume"), formatSize(size))
+	for _, part := range parts {
+		fmt.Printf("  %s\n", filepath.Base(part))
+	}
+	return nil
+}
+
+// splitFile 按字节把 file 切为 file.001 / file.aa 形式的分卷，失败时删除已写出的卷
+func splitFile(file string, size int64, style volumeStyle) (parts []string, err error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	count := int(max((fi.Size()+size-1)/size, 1))
+	if count > volumeLimits[style] {
+		return nil, fmt.Errorf("%s would need %d volumes, use a larger --volume-size", filepath.Base(file), count)
+	}
+	defer func() {
+		if err != nil {
+			for _, part := range parts {
+				os.Remove(part)
+			}
+			parts = nil
+		}
+	}()
+	for i := 0; i < count; i++ {
+		name := volumeFile(style, file, i, 2)
+		out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, name)
+		_, err = io.CopyN(out, in, size)
+		if err == io.EOF {
+			err = nil
+		}
+		if err == nil {
+			err = out.Sync()
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return parts, err
+		}
+	}
+	return parts, nil
+}
+
+// splitZip 用 zip -s 把 archive 改写为 .z01、.z02 ... 与最后一卷 .zip。
+// 分卷 zip 的每卷都有自己的偏移与卷号，不能按字节切分
+func splitZip(archive string, size int64) ([]string, error) {
+	if !commandExists("zip") {
+		return nil, fmt.Errorf("zip command is required to write split zip volumes")
+	}
+	if size < 64<<10 {
+		return nil, fmt.Errorf("zip volumes must be at least 64K")
+	}
+	if err := checkVolumesFree(archive); err != nil {
+		return nil, err
+	}
+	dir, base := filepath.Split(archive)
+	// zip -s 不能原地改写，先把完整的归档移到同目录下的临时名
+	whole := filepath.Join(dir, ".unbox-split-"+base)
+	if err := os.Rename(archive, whole); err != nil {
+		return nil, err
+	}
+	pendingFiles.Store(whole, true)
+	defer pendingFiles.Delete(whole)
+	if err := runCommand("zip", "-q", "-s", strconv.FormatInt(size>>10, 10)+"k", whole, "--out", archive); err != nil {
+		os.Remove(archive)
+		os.Rename(whole, archive)
+		return nil, err
+	}
+	os.Remove(whole)
+	return findVolumes(archive).parts, nil
+}
+
+// joinVolumes 把 split 或 7z -v 产生的原始切分拼回完整文件，out 为空时使用去掉卷号后的名称
+func joinVolumes(file, out string) error {
+	if _, err := os.Stat(file); err != nil {
+		return err
+	}
+	set := findVolumes(file)
+	if set.style != volSplit && set.style != volNumbered {
+		return fmt.Errorf("'%s' is not a raw split volume (.aa / .001)", file)
+	}
+	if err := set.check(); err != nil {
+		return err
+	}
+	if out == "" {
+		out = set.name
+	}
+	if _, err := os.Lstat(out); err == nil {
+		return fmt.Errorf("'%s' already exists", out)
+	}
+	fmt.Printf("Joining: %s -> %s\n", set, out)
+	return joinFiles(out, set.parts)
+}
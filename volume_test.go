@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTree 在 dir 下写出一个目录与其中的随机内容文件，返回文件内容
+func writeTestTree(t *testing.T, dir string) []byte {
+	t.Helper()
+	data := make([]byte, 200000)
+	rand.Read(data)
+	if err := os.MkdirAll(filepath.Join(dir, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "d", "f"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkExtracted 确认 dest 下解压出的 d/f 与 want 相同
+func checkExtracted(t *testing.T, dest string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(dest, "d", "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("d/f differs after extraction (%d bytes, want %d)", len(got), len(want))
+	}
+}
+
+func TestNumberedTarZstVolumes(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	tmp := t.TempDir()
+	data := writeTestTree(t, tmp)
+	archive := filepath.Join(tmp, "x.tar.zst")
+	if err := createArchive(archive, []string{filepath.Join(tmp, "d")}, &AddOptions{}, &CreateParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 像 split -d -a 3 一样按字节切为 .001、.002 ...
+	whole, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(archive)
+	size := len(whole)/3 + 1
+	for i := 0; i*size < len(whole); i++ {
+		part := whole[i*size : min((i+1)*size, len(whole))]
+		if err := os.WriteFile(fmt.Sprintf("%s.%03d", archive, i+1), part, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set := findVolumes(archive + ".002")
+	if len(set.parts) != 3 || !set.join {
+		t.Fatalf("found %v, join %v", set.parts, set.join)
+	}
+	dest := filepath.Join(tmp, "out")
+	if err := set.extract(dest, &Options{Limits: defaultLimits()}); err != nil {
+		t.Fatal(err)
+	}
+	checkExtracted(t, dest, data)
+}
+
+func TestStrayZ01BesideOrdinaryZip(t *testing.T) {
+	tmp := t.TempDir()
+	writeTestTree(t, tmp)
+	archive := filepath.Join(tmp, "o.zip")
+	if err := createArchive(archive, []string{filepath.Join(tmp, "d")}, &AddOptions{}, &CreateParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "o.z01"), []byte("stray"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{archive, filepath.Join(tmp, "o.z01")} {
+		set := findVolumes(file)
+		if len(set.parts) != 1 || set.parts[0] != file || len(set.missing) != 0 {
+			t.Errorf("%s: found %v, missing %v", filepath.Base(file), set.parts, set.missing)
+		}
+	}
+}
+
+func TestSplitZipRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("zip"); err != nil {
+		t.Skip("zip is not installed")
+	}
+	tmp := t.TempDir()
+	data := writeTestTree(t, tmp)
+	archive := filepath.Join(tmp, "o.zip")
+	params := &CreateParams{VolumeSize: 64 << 10}
+	if err := createArchive(archive, []string{filepath.Join(tmp, "d")}, &AddOptions{}, params); err != nil {
+		t.Fatal(err)
+	}
+
+	set := findVolumes(archive)
+	if set.style != volZip || len(set.parts) < 3 || len(set.missing) != 0 {
+		t.Fatalf("found %v, missing %v", set.parts, set.missing)
+	}
+	opts := &Options{Limits: defaultLimits()}
+	if !testArchives([]string{archive}, false, opts) {
+		t.Fatal("testing the split zip failed")
+	}
+	sums := make(map[string]string)
+	if err := hashFile(archive, "sha256", opts, func(name, sum string) { sums[name] = sum }); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(data)); len(sums) != 1 || sums["d/f"] != want {
+		t.Fatalf("hashes: %v", sums)
+	}
+	dest := filepath.Join(tmp, "out")
+	if err := set.extract(dest, opts); err != nil {
+		t.Fatal(err)
+	}
+	checkExtracted(t, dest, data)
+}
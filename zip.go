@@ -0,0 +1,581 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============== zip 后端 ==============
+
+type zipFormat struct{}
+
+func init() {
+	registerFormat(zipFormat{})
+}
+
+var zipSignatures = []magicSignature{
+	{0, []byte("PK\x03\x04")},
+	{0, []byte("PK\x05\x06")}, // 空 zip
+	{0, []byte("PK\x07\x08")}, // 分卷 zip 的首卷
+}
+
+func (zipFormat) Name() archiveKind { return kindZip }
+
+func (zipFormat) Extensions() []string {
+	return []string{".zip", ".jar", ".war", ".ear", ".apk", ".whl", ".docx", ".xlsx", ".pptx", ".odt", ".epub"}
+}
+
+func (zipFormat) Detect(p *probe) bool { return p.hasAnyMagic(zipSignatures) }
+
+func (zipFormat) Capabilities() Capability {
+	return CapList | CapExtract | CapCreate | CapNative | CapEncrypt
+}
+
+func (zipFormat) List(archive string) ([]Entry, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	entries := make([]Entry, 0, len(zr.File))
+	for _, f := range zr.File {
+		e := zipEntry(f)
+		switch {
+		case e.Mode&os.ModeSymlink != 0:
+			if rc, err := f.Open(); err == nil {
+				link, _ := io.ReadAll(rc)
+				rc.Close()
+				e.Linkname = string(link)
+			}
+		case e.Mode.IsRegular() && f.Flags&0x1 == 0:
+			if rc, err := f.Open(); err == nil {
+				e.IsArchive = sniffEntry(f.Name, readHead(rc))
+				rc.Close()
+			} else {
+				e.IsArchive = formatFromName(f.Name) != nil
+			}
+		case e.Mode.IsRegular():
+			// 加密条目不解密就读不到文件头，只能按扩展名识别
+			e.IsArchive = formatFromName(f.Name) != nil
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func zipEntry(f *zip.File) Entry {
+	return Entry{
+		Name:    strings.TrimSuffix(f.Name, "/"),
+		Size:    int64(f.UncompressedSize64),
+		Packed:  int64(f.CompressedSize64),
+		Mode:    f.Mode(),
+		ModTime: f.Modified,
+		CRC:     f.CRC32,
+		HasCRC:  !f.Mode().IsDir(),
+	}
+}
+
+// Walk 按中央目录的顺序读出全部条目，用于格式转换
+func (zipFormat) Walk(archive string, fn func(e *Entry, r io.Reader) error) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %v", err)
+	}
+	defer zr.Close()
+	if zipNeedsFallback(&zr.Reader) {
+		return errNotStreamable
+	}
+
+	for _, f := range zr.File {
+		e := zipEntry(f)
+		if e.IsDir() {
+			if err := fn(&e, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if e.Mode&os.ModeSymlink != 0 {
+			link, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.Name, err)
+			}
+			e.Linkname = string(link)
+			err = fn(&e, nil)
+		} else {
+			err = fn(&e, rc)
+			rc.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (zipFormat) Open(archive, name string) (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %v", err)
+	}
+	for _, f := range zr.File {
+		if strings.TrimSuffix(f.Name, "/") != name {
+			continue
+		}
+		if f.Flags&0x1 != 0 || (f.Method != zip.Store && f.Method != zip.Deflate) {
+			zr.Close()
+			if !commandExists("7z") {
+				return nil, fmt.Errorf("%s: encrypted or unsupported zip entry, 7z command is required", name)
+			}
+			return open7zEntry(archive, name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return streamReader{rc, multiCloser{rc, zr}}, nil
+	}
+	zr.Close()
+	return nil, fmt.Errorf("'%s' not found in %s", name, filepath.Base(archive))
+}
+
+// zipNeedsFallback 判断 zip 是否包含标准库无法处理的条目（加密或非 Store/Deflate 压缩）
+func zipNeedsFallback(zr *zip.Reader) bool {
+	for _, f := range zr.File {
+		if f.Flags&0x1 != 0 {
+			return true
+		}
+		if f.Method != zip.Store && f.Method != zip.Deflate {
+			return true
+		}
+	}
+	return false
+}
+
+// zipEncrypted 判断 zip 是否含加密条目
+func zipEncrypted(archive string) bool {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return false
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Flags&0x1 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (zipFormat) Extract(archive, dest string, opts *Options) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	root, err := newExtractRoot(archive, dest, opts)
+	if err != nil {
+		return err
+	}
+
+	if zipNeedsFallback(&zr.Reader) {
+		if !commandExists("7z") {
+			return fmt.Errorf("'%s' contains encrypted or unsupported zip entries, 7z command is required", filepath.Base(archive))
+		}
+		var entries []Entry
+		for _, f := range zr.File {
+			entries = append(entries, Entry{Name: f.Name, Size: int64(f.UncompressedSize64)})
+		}
+		if err := root.checkEntries(entries); err != nil {
+			return err
+		}
+		if err := root.checkDeclared(entries); err != nil {
+			return err
+		}
+		return extract7z(archive, dest)
+	}
+
+	dirs := dirTimes{}
+	defer dirs.restore()
+
+	for _, f := range zr.File {
+		target, err := root.target(f.Name)
+		if err != nil {
+			return err
+		}
+		if err := root.addEntry(); err != nil {
+			return err
+		}
+		mode := f.Mode()
+
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs[target] = f.Modified
+		case mode&os.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			link, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			if err := root.checkSymlink(f.Name, target, string(link)); err != nil {
+				return err
+			}
+			if err := writeSymlink(target, string(link)); err != nil {
+				return err
+			}
+		default:
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			err = root.writeFile(target, rc, mode.Perm(), f.Modified)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (zipFormat) Create(archive, sourceDir string) error {
+	return writeZip(archive, sourceDir)
+}
+
+// zipLevel 根据通用标志位的第 1、2 位推断 Deflate 的压缩级别
+func zipLevel(files []*zip.File) int {
+	for _, f := range files {
+		if f.Method != zip.Deflate {
+			continue
+		}
+		switch (f.Flags >> 1) & 0x3 {
+		case 1:
+			return flate.BestCompression
+		case 2, 3:
+			return flate.BestSpeed
+		}
+		return flate.DefaultCompression
+	}
+	return flate.DefaultCompression
+}
+
+// zipDefaultMethod 返回新增条目使用的压缩方法：原归档的文件全部为 Store 时沿用 Store
+func zipDefaultMethod(files []*zip.File) uint16 {
+	stored := false
+	for _, f := range files {
+		if f.Mode().IsRegular() && f.UncompressedSize64 > 0 {
+			if f.Method != zip.Store {
+				return zip.Deflate
+			}
+			stored = true
+		}
+	}
+	if stored {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// zipUnchanged 判断磁盘上的文件与原条目内容是否一致（大小与 CRC32 相同）
+func zipUnchanged(f *zip.File, path string, fi os.FileInfo) bool {
+	if !fi.Mode().IsRegular() || uint64(fi.Size()) != f.UncompressedSize64 || fi.Mode().Perm() != f.Mode().Perm() {
+		return false
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer in.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, in); err != nil {
+		return false
+	}
+	return h.Sum32() == f.CRC32
+}
+
+// writeZip 把 sourceDir 打包为 zip
+func writeZip(archive, sourceDir string) error {
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	err = walkSourceDir(sourceDir, func(name, path string, fi os.FileInfo) error {
+		return writeZipEntry(zw, name, path, fi, nil, zip.Deflate)
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Build 按 adds 的顺序写出新的 zip 归档，params.Level 为 Deflate 级别。
+// 标准库不能写加密条目，加密时交给 7z
+func (zipFormat) Build(archive string, adds []EditAdd, params *CreateParams) error {
+	if params.Password != "" {
+		if !commandExists("7z") {
+			return fmt.Errorf("7z command is required to encrypt zip archives, please install p7zip")
+		}
+		return build7z(archive, kindZip, adds, params)
+	}
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	if params.Level != 0 {
+		level := params.Level
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	for _, add := range adds {
+		var fi os.FileInfo
+		if fi, err = os.Lstat(add.Path); err != nil {
+			break
+		}
+		if err = writeZipEntry(zw, add.Name, add.Path, fi, nil, zip.Deflate); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// zipEntryWriter 把数据流中的条目写入 zip，用于格式转换
+type zipEntryWriter struct {
+	zw  *zip.Writer
+	out *os.File
+}
+
+func (zipFormat) NewEntryWriter(archive string, params *CreateParams) (EntryWriter, error) {
+	out, err := os.Create(archive)
+	if err != nil {
+		return nil, err
+	}
+	zw := zip.NewWriter(out)
+	if params.Level != 0 {
+		level := params.Level
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	return &zipEntryWriter{zw: zw, out: out}, nil
+}
+
+// WriteEntry 写入目录、普通文件与符号链接；zip 无法表示硬链接，其余特殊条目跳过
+func (w *zipEntryWriter) WriteEntry(e *Entry, r io.Reader) error {
+	hdr := &zip.FileHeader{Name: e.Name, Modified: e.ModTime}
+	hdr.SetMode(e.Mode)
+	switch {
+	case e.IsDir():
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+		_, err := w.zw.CreateHeader(hdr)
+		return err
+	case e.Mode&os.ModeSymlink != 0:
+		hdr.Method = zip.Store
+		zf, err := w.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(zf, e.Linkname)
+		return err
+	case e.Mode.IsRegular() && e.Linkname != "":
+		return fmt.Errorf("'%s' is a hard link to '%s', which zip cannot store", e.Name, e.Linkname)
+	case !e.Mode.IsRegular():
+		return nil
+	}
+	hdr.Method = zip.Deflate
+	zf, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zf, r)
+	return err
+}
+
+func (w *zipEntryWriter) Close() error {
+	err := w.zw.Close()
+	if cerr := w.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Edit 逐条复制原归档的压缩数据，只有被替换或新增的条目需要压缩
+func (zipFormat) Edit(original, archive string, edit *ArchiveEdit) error {
+	zr, err := zip.OpenReader(original)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	zw, method, err := newZipWriter(out, &zr.Reader)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	index := zipIndex(&zr.Reader)
+
+	for _, f := range zr.File {
+		name := cleanEntryName(f.Name)
+		if edit.removes(name) || edit.replaces(name) {
+			continue
+		}
+		if err = copyZipEntry(zw, f); err != nil {
+			break
+		}
+	}
+	for _, add := range edit.Adds {
+		if err != nil {
+			break
+		}
+		var fi os.FileInfo
+		if fi, err = os.Lstat(add.Path); err != nil {
+			break
+		}
+		orig := index[add.Name]
+		if add.KeepMeta && orig != nil && orig.Mode().IsRegular() && fi.Mode().IsRegular() {
+			fi = keepZipMeta{fi, orig}
+		}
+		err = writeZipEntry(zw, add.Name, add.Path, fi, orig, method)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// keepZipMeta 让新内容沿用原条目的权限位
+type keepZipMeta struct {
+	os.FileInfo
+	orig *zip.File
+}
+
+func (k keepZipMeta) Mode() os.FileMode { return k.orig.Mode() }
+
+// newZipWriter 创建沿用 tmpl 的归档注释与 Deflate 级别的 zip 写入器，并返回新增条目使用的压缩方法
+func newZipWriter(out io.Writer, tmpl *zip.Reader) (*zip.Writer, uint16, error) {
+	zw := zip.NewWriter(out)
+	level := zipLevel(tmpl.File)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	if err := zw.SetComment(tmpl.Comment); err != nil {
+		return nil, 0, err
+	}
+	return zw, zipDefaultMethod(tmpl.File), nil
+}
+
+// zipIndex 按条目名索引原归档
+func zipIndex(zr *zip.Reader) map[string]*zip.File {
+	index := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		index[cleanEntryName(f.Name)] = f
+	}
+	return index
+}
+
+// writeZipEntry 写入一个条目。orig 为原归档中的同名条目：内容未变时原样复制，否则沿用它的压缩方法与注释
+func writeZipEntry(zw *zip.Writer, name, path string, fi os.FileInfo, orig *zip.File, method uint16) error {
+	if orig != nil && orig.Mode().IsRegular() && zipUnchanged(orig, path, fi) {
+		return copyZipEntry(zw, orig)
+	}
+
+	hdr, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if orig != nil {
+		hdr.Comment = orig.Comment
+	}
+	switch {
+	case fi.IsDir():
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+		_, err = zw.CreateHeader(hdr)
+		return err
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		hdr.Method = zip.Store
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, link)
+		return err
+	case !fi.Mode().IsRegular():
+		return nil
+	}
+
+	hdr.Method = method
+	if orig != nil && (orig.Method == zip.Store || orig.Method == zip.Deflate) {
+		hdr.Method = orig.Method
+	}
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// copyZipEntry 原样复制条目的压缩数据，不解压也不重新压缩
+func copyZipEntry(zw *zip.Writer, f *zip.File) error {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	hdr := f.FileHeader
+	w, err := zw.CreateRaw(&hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, raw)
+	return err
+}